@@ -0,0 +1,39 @@
+package ssdeep
+
+import (
+	"runtime"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// BatchFiles hashes the files at paths in parallel using up to workers goroutines,
+// or runtime.NumCPU() when workers <= 0. It returns a map from path to hash holding
+// only the successfully hashed files, and a slice of errors in the same order as
+// paths, with nil entries for successes.
+func BatchFiles(paths []string, workers int) (map[string]string, []error) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	hashes := make([]string, len(paths))
+	errs := make([]error, len(paths))
+
+	var g errgroup.Group
+	g.SetLimit(workers)
+	for i, path := range paths {
+		g.Go(func() error {
+			hashes[i], errs[i] = File(path)
+			return nil
+		})
+	}
+	g.Wait()
+
+	result := make(map[string]string, len(paths))
+	for i, path := range paths {
+		if errs[i] == nil {
+			result[path] = hashes[i]
+		}
+	}
+
+	return result, errs
+}
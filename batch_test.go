@@ -0,0 +1,42 @@
+package ssdeep
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	var (
+		paths    []string
+		expected = make(map[string]string)
+	)
+	for i := range 100 {
+		data := []byte(fmt.Sprintf("file %d: The quick brown fox jumps over the lazy dog %d times", i, i*i))
+		path := filepath.Join(dir, fmt.Sprintf("file%03d", i))
+		require.NoError(t, os.WriteFile(path, data, 0o600))
+
+		hash, err := Bytes(data)
+		require.NoError(t, err)
+		paths = append(paths, path)
+		expected[path] = hash
+	}
+	missing := filepath.Join(dir, "missing")
+	paths = append(paths, missing)
+
+	hashes, errs := BatchFiles(paths, 8)
+	require.Len(t, errs, len(paths))
+	require.Equal(t, expected, hashes)
+	for i, err := range errs {
+		if paths[i] == missing {
+			require.ErrorIs(t, err, os.ErrNotExist)
+		} else {
+			require.NoError(t, err)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package ssdeep
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestCrossCompile checks that platform-specific code is confined to build-tagged
+// files, so the package and CLI still build for targets without unix syscalls.
+// pkg/hashdb is left out, as its SQLite driver does not support wasm.
+func TestCrossCompile(t *testing.T) {
+	if testing.Short() {
+		t.Skip("cross-compiling is slow")
+	}
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not found")
+	}
+
+	for _, target := range [][2]string{
+		{"js", "wasm"},
+		{"wasip1", "wasm"},
+		{"windows", "amd64"},
+		{"darwin", "arm64"},
+	} {
+		t.Run(target[0]+"/"+target[1], func(t *testing.T) {
+			cmd := exec.Command(gobin, "build", ".", "./cmd/...")
+			cmd.Env = append(os.Environ(), "GOOS="+target[0], "GOARCH="+target[1], "CGO_ENABLED=0")
+			out, err := cmd.CombinedOutput()
+			require.NoError(t, err, "%s", out)
+		})
+	}
+}
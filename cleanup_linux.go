@@ -0,0 +1,19 @@
+package ssdeep
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// evictCache flushes file and drops its pages from the page cache, so that a large
+// spill file does not push more useful data out of memory
+func evictCache(file *os.File) {
+	fd := int(file.Fd())
+	// sync unwritten dirty pages
+	syscall.Fdatasync(fd)
+
+	// clear page cache
+	unix.Fadvise(fd, 0, 0, unix.FADV_DONTNEED)
+}
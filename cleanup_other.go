@@ -0,0 +1,11 @@
+//go:build !linux && !windows
+
+package ssdeep
+
+import "os"
+
+// evictCache flushes file; dropping cached pages is only supported on Linux.
+// This also covers js/wasm and wasip1, where Sync may simply fail.
+func evictCache(file *os.File) {
+	file.Sync()
+}
@@ -0,0 +1,13 @@
+package ssdeep
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// evictCache flushes file's buffers to disk. Windows has no call to drop a file's
+// cached pages; they are released once the file is closed and deleted.
+func evictCache(file *os.File) {
+	windows.FlushFileBuffers(windows.Handle(file.Fd()))
+}
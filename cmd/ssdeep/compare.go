@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+
+	"github.com/cosmorse/ssdeep"
+	"github.com/spf13/cobra"
+)
+
+// Values of --format
+const (
+	formatText = "text"
+	formatJSON = "json"
+)
+
+// format is the output format of compare
+var format string
+
+var compareCmd = &cobra.Command{
+	Use:   "compare [options] dir1 dir2",
+	Short: "print the similar pairs of files between two directories",
+	Long: "compare hashes the files under two directories, walking them recursively like hash, and prints\n" +
+		"every pair of files, one from each directory, scoring at least --min-score.",
+	Args:                  validateCompareArgs,
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		runCompare(args[0], args[1])
+	},
+}
+
+// validateCompareArgs requires exactly two directories and a known --format
+func validateCompareArgs(cmd *cobra.Command, args []string) error {
+	if format != formatText && format != formatJSON {
+		return fmt.Errorf("invalid --format %q, want %q or %q", format, formatText, formatJSON)
+	}
+	return cobra.ExactArgs(2)(cmd, args)
+}
+
+// comparePair is a compare match, as printed with --format json
+type comparePair struct {
+	Path1 string `json:"path1"`
+	Path2 string `json:"path2"`
+	Score int    `json:"score"`
+}
+
+// runCompare hashes the files under dir1 and dir2 and prints every pair, one file
+// from each side, scoring at least --min-score. The files of dir2 are indexed by block
+// size, so that each file of dir1 is only scored against those it can match, and
+// pairs are printed as they are scored.
+func runCompare(dir1, dir2 string) {
+	paths1, hashes1 := hashTree(dir1)
+	// Paths resolving to the same file are skipped within each side, but a file
+	// under both directories is on both sides
+	clear(seen)
+	paths2, hashes2 := hashTree(dir2)
+
+	idx := newHashIndex()
+	for j, hash := range hashes2 {
+		if h, err := ssdeep.Parse(hash); err == nil {
+			idx.add(h, hashInfo{hash: hash, path: paths2[j]})
+			idx.lines++
+		}
+	}
+
+	enc := json.NewEncoder(stdout)
+	for i, hash := range hashes1 {
+		h, err := ssdeep.Parse(hash)
+		if err != nil {
+			reportError(paths1[i], err)
+			continue
+		}
+		for _, other := range idx.candidates(int(h.BlockSize)) {
+			score, err := compareHashes(paths1[i], hash, other.hash)
+			if err != nil || score == 0 || score < minScore {
+				continue
+			}
+			pair := comparePair{Path1: displayPath(paths1[i]), Path2: displayPath(other.path), Score: score}
+			if format == formatJSON {
+				err = enc.Encode(pair)
+			} else {
+				_, err = fmt.Fprintf(stdout, "%s <-> %s (%d)\n", pair.Path1, pair.Path2, pair.Score)
+			}
+			if err != nil {
+				reportError("stdout", err)
+				return
+			}
+		}
+	}
+}
+
+// hashTree hashes the files under root in parallel with BatchFiles, returning the
+// paths hashed successfully, in walk order, and their hashes. Files are selected like
+// hash selects them. BatchFiles has no time limit, so with --file-timeout the files
+// are hashed one at a time instead.
+func hashTree(root string) (paths, hashes []string) {
+	var files []string
+	visitFiles(root, func(p string, info fs.FileInfo) {
+		if minSize > 0 {
+			info, err := statIfNil(p, info)
+			if err != nil {
+				reportError(p, err)
+				return
+			}
+			if info.Size() < minSize {
+				return
+			}
+		}
+		files = append(files, p)
+	})
+
+	hashed, errs := map[string]string{}, make([]error, len(files))
+	if fileTimeout > 0 {
+		for i, p := range files {
+			hashed[p], errs[i] = hashFile(p)
+		}
+	} else {
+		hashed, errs = ssdeep.BatchFiles(files, 0)
+	}
+	for i, p := range files {
+		if errs[i] != nil {
+			reportError(p, errs[i])
+			continue
+		}
+		succeeded = true
+		paths = append(paths, p)
+		hashes = append(hashes, hashed[p])
+	}
+	return paths, hashes
+}
+
+func init() {
+	flags := compareCmd.Flags()
+	addWalkFlags(flags)
+	flags.IntVar(&minScore, "min-score", 90, "the lowest score printed")
+	flags.StringVar(&format, "format", formatText, "output format: text or json (one object per line)")
+	rootCmd.AddCommand(compareCmd)
+}
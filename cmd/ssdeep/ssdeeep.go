@@ -2,45 +2,214 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/cosmorse/ssdeep"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
+// Version is the CLI version, set at build time via
+// -ldflags "-X main.Version=v1.2.3"
+var Version = "dev"
+
+var (
+	silent      bool
+	matchFiles  []string
+	fileTimeout time.Duration
+	noDedup     bool
+	fromStdin   bool
+	nullInput   bool
+	normalize   bool
+	dedupMode   string
+	minScore    int
+	withMeta    bool
+	minSize     int64
+	firstMatch  bool
+	warnCase    bool
+	hashOnly    bool
+)
+
+// Values of --deduplicate
+const (
+	dedupExact = "exact"
+	dedupFuzzy = "fuzzy"
+)
+
+// results collects the hashes computed while --deduplicate is set, to print at the end
+var results []hashInfo
+
+// stdin, stdout and stderr are the standard streams, replaceable in tests
 var (
-	silent    bool
-	matchFile string
+	stdin  io.Reader = os.Stdin
+	stdout io.Writer = os.Stdout
+	stderr io.Writer = os.Stderr
 )
 
+// seen holds the canonical paths already processed in this run
+var seen = make(map[string]bool)
+
+// hasError records that some file could not be processed, succeeded that some
+// file was hashed, drifted that verify found a changed file and matched that
+// match mode printed a match; together they select the exit status (see exitCode)
+var hasError, succeeded, drifted, matched bool
+
+// Exit statuses of match mode, which like grep tell scripts whether anything matched
+const (
+	exitMatch   = 0
+	exitNoMatch = 1
+	exitError   = 2
+)
+
+// exitCode returns the exit status of the run: 0 if every file was hashed (and
+// verified unchanged), 1 if some files failed or changed and 2 if all of them failed.
+// In match mode it is exitMatch if some file matched and no error occurred,
+// exitNoMatch if none matched and exitError if any file failed.
+func exitCode() int {
+	if len(matchFiles) > 0 {
+		switch {
+		case hasError:
+			return exitError
+		case matched:
+			return exitMatch
+		default:
+			return exitNoMatch
+		}
+	}
+
+	switch {
+	case !hasError && !drifted:
+		return 0
+	case succeeded:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// failedStatus returns the exit status of a run that failed before processing files,
+// such as one with invalid arguments: exitError in match mode, 1 otherwise. cmd is
+// the command run, which tells match mode apart when the flags did not parse.
+func failedStatus(cmd *cobra.Command) int {
+	if cmd != nil && cmd.Name() == "match" || len(matchFiles) > 0 {
+		return exitError
+	}
+	return 1
+}
+
 var rootCmd = &cobra.Command{
-	Use:                   "ssdeep [options] files",
-	Short:                 "ssdeep fuzzy hashing tool",
-	Long:                  "ssdeep is a tool for computing and matching fuzzy hashes (Context Triggered Piecewise Hashing).",
-	Args:                  cobra.MinimumNArgs(1),
+	Use:   "ssdeep command [options] files",
+	Short: "ssdeep fuzzy hashing tool",
+	Long: "ssdeep is a tool for computing and matching fuzzy hashes (Context Triggered Piecewise Hashing).\n\n" +
+		"Files are hashed with \"ssdeep hash\" and matched against known hashes with \"ssdeep match\".\n" +
+		"The flag-based interface of earlier versions, such as \"ssdeep -m hashes.txt files\", remains\n" +
+		"available with --compat until the next major version.",
+	Args:                  validateRootArgs,
 	DisableFlagsInUseLine: true,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		clear(seen)
+		hasError, succeeded, drifted, matched = false, false, false, false
+		results = nil
+	},
 	Run: func(cmd *cobra.Command, args []string) {
-		if matchFile != "" {
-			runMatch(args)
+		if !compat {
+			cmd.Help()
 			return
 		}
+		runFiles(args)
+	},
+}
 
-		for _, arg := range args {
-			processPath(arg)
+// runFiles processes the files given, and those named on stdin with --from-stdin,
+// in the mode selected by the flags
+func runFiles(args []string) {
+	if fromStdin {
+		paths, err := readPaths(stdin)
+		if err != nil {
+			if !silent {
+				fmt.Fprintf(stderr, "ssdeep: %v\n", err)
+			}
+			os.Exit(failedStatus(nil))
 		}
-	},
+		args = append(args, paths...)
+	}
+
+	if len(matchFiles) > 0 {
+		runMatch(args)
+		return
+	}
+	for _, arg := range args {
+		processPath(arg)
+	}
+	if dedupMode != "" {
+		printDeduplicated(results)
+	}
+}
+
+// compareHashes compares the hash of the file at path with another hash. Hashes whose
+// block sizes cannot be compared score 0 with a warning, unless --silent is set, since
+// 0 would otherwise read as "not similar".
+func compareHashes(path, hash, other string) (int, error) {
+	score, err := ssdeep.Compare(hash, other)
+	if errors.Is(err, ssdeep.ErrIncompatibleBlockSizes) {
+		if !silent {
+			fmt.Fprintf(stderr, "ssdeep: warning: %s: cannot compare %s with %s: %v\n", path, hash, other, err)
+		}
+		return 0, nil
+	}
+	return score, err
+}
+
+// reportError prints an error for path unless --silent is set, and records the failure
+func reportError(path string, err error) {
+	hasError = true
+	if !silent {
+		fmt.Fprintf(stderr, "ssdeep: %s: %v\n", path, err)
+	}
+}
+
+// walkError reports an error met while walking to path. An unreadable directory
+// only skips its own subtree, so its siblings are still processed.
+func walkError(path string, d fs.DirEntry, err error) error {
+	reportError(path, err)
+	if d != nil && d.IsDir() {
+		return filepath.SkipDir
+	}
+	return nil
+}
+
+// validateArgs requires at least one file unless file names come from stdin
+func validateArgs(cmd *cobra.Command, args []string) error {
+	if dedupMode != "" && dedupMode != dedupExact && dedupMode != dedupFuzzy {
+		return fmt.Errorf("invalid --deduplicate mode %q, want %q or %q", dedupMode, dedupExact, dedupFuzzy)
+	}
+	if hashOnly && withMeta {
+		return errors.New("--hash-only cannot be combined with --with-meta")
+	}
+	if fromStdin {
+		return nil
+	}
+	return cobra.MinimumNArgs(1)(cmd, args)
 }
 
 func runMatch(args []string) {
-	hashes, err := loadHashes(matchFile)
+	hashes, err := loadHashes(matchFiles...)
 	if err != nil {
 		if !silent {
-			fmt.Fprintf(os.Stderr, "ssdeep: %v\n", err)
+			fmt.Fprintf(stderr, "ssdeep: %v\n", err)
 		}
-		os.Exit(1)
+		os.Exit(exitError)
 	}
 
 	for _, arg := range args {
@@ -48,117 +217,450 @@ func runMatch(args []string) {
 	}
 }
 
+// readPaths reads file names from r, one per line, or NUL-separated when
+// --null-input is set or the input contains a NUL byte
+func readPaths(r io.Reader) ([]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	sep := "\n"
+	if nullInput || bytes.IndexByte(data, 0) >= 0 {
+		sep = "\x00"
+	}
+
+	var paths []string
+	for _, p := range strings.Split(string(data), sep) {
+		if sep == "\n" {
+			p = strings.TrimSuffix(p, "\r")
+		}
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths, nil
+}
+
 type hashInfo struct {
 	hash string
 	path string
+	meta string // Size and mtime fields printed after the path with --with-meta
+	line int    // Position in the hash file, to report matches in file order
 }
 
-func loadHashes(path string) ([]hashInfo, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
+// parseRecord splits an output line, `hash,"path"` optionally followed by the
+// --with-meta fields, into its hash and path
+func parseRecord(line string) (hash, path string, ok bool) {
+	hash, rest, ok := strings.Cut(line, ",")
+	if !ok {
+		return "", "", false
 	}
-	defer file.Close()
+	if strings.HasPrefix(rest, "\"") {
+		// Paths are not escaped, so the closing quote is the last one on the line
+		if end := strings.LastIndexByte(rest, '"'); end > 0 {
+			return hash, rest[1:end], true
+		}
+	}
+	return hash, strings.Trim(rest, "\""), true
+}
+
+// metaFields returns the --with-meta fields of a record: the file size in bytes and
+// the modification time in RFC 3339 format, in UTC
+func metaFields(info fs.FileInfo) string {
+	return fmt.Sprintf(",%d,%s", info.Size(), info.ModTime().UTC().Format(time.RFC3339Nano))
+}
 
+// hashIndex holds the known hashes bucketed by block size, so that a file is only
+// compared against the hashes whose block size is compatible with its own
+type hashIndex struct {
+	buckets map[int][]hashInfo
+	seen    map[hashInfo]bool // Hash and path pairs already loaded, with line 0
+	lines   int               // Lines loaded so far, over all hash files
+}
+
+// candidates returns the hashes that can match a hash with blockSize, in file order
+func (idx *hashIndex) candidates(blockSize int) []hashInfo {
 	var hashes []hashInfo
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		parts := strings.SplitN(line, ",", 2)
-		if len(parts) == 2 {
-			hash := parts[0]
-			targetPath := strings.Trim(parts[1], "\"")
-			hashes = append(hashes, hashInfo{hash: hash, path: targetPath})
+	for _, bs := range ssdeep.CompatibleBlockSizes(blockSize) {
+		if bs != 0 {
+			hashes = append(hashes, idx.buckets[bs]...)
 		}
 	}
-	return hashes, scanner.Err()
+	slices.SortFunc(hashes, func(a, b hashInfo) int {
+		return a.line - b.line
+	})
+	return hashes
 }
 
-func matchPath(path string, hashes []hashInfo) {
-	info, err := os.Stat(path)
-	if err != nil {
-		if !silent {
-			fmt.Fprintf(os.Stderr, "ssdeep: %s: %v\n", path, err)
-		}
+func newHashIndex() *hashIndex {
+	return &hashIndex{
+		buckets: make(map[int][]hashInfo),
+		seen:    make(map[hashInfo]bool),
+	}
+}
+
+// add indexes info, whose hash parses as h, unless the same hash and path were added before
+func (idx *hashIndex) add(h ssdeep.HashInfo, info hashInfo) {
+	if idx.seen[info] {
 		return
 	}
+	idx.seen[info] = true
 
-	if info.IsDir() {
-		filepath.Walk(path, func(p string, i os.FileInfo, e error) error {
-			if e != nil {
-				if !silent {
-					fmt.Fprintf(os.Stderr, "ssdeep: %s: %v\n", p, e)
-				}
-				return nil
+	info.line = idx.lines
+	bs := int(h.BlockSize)
+	idx.buckets[bs] = append(idx.buckets[bs], info)
+}
+
+// loadHashes reads and merges hash files. Lines whose hash does not parse could never
+// match, so they are dropped, as are hash and path pairs listed more than once.
+func loadHashes(paths ...string) (*hashIndex, error) {
+	idx := newHashIndex()
+	for _, path := range paths {
+		if err := idx.load(path); err != nil {
+			return nil, err
+		}
+	}
+	return idx, nil
+}
+
+// load adds the hashes of one hash file to idx
+func (idx *hashIndex) load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var folded, firstFolded int
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line, idx.lines = line+1, idx.lines+1 {
+		hash, path, ok := parseRecord(scanner.Text())
+		if ok {
+			h, err := ssdeep.Parse(hash)
+			if err != nil {
+				continue
 			}
-			if !i.IsDir() {
-				matchFileAgainstHashes(p, hashes)
+			if warnCase && looksCaseFolded(h) {
+				if folded++; folded == 1 {
+					firstFolded = line
+				}
 			}
-			return nil
-		})
-	} else {
-		matchFileAgainstHashes(path, hashes)
+			idx.add(h, hashInfo{hash: hash, path: path})
+		}
+	}
+	if folded > 0 && !silent {
+		fmt.Fprintf(stderr, "ssdeep: warning: %s: %d hashes look case-folded, starting at line %d; comparisons against them are unreliable\n",
+			path, folded, firstFolded)
 	}
+	return scanner.Err()
 }
 
-func matchFileAgainstHashes(path string, hashes []hashInfo) {
-	hash, err := ssdeep.File(path)
-	if err != nil {
-		if !silent {
-			fmt.Fprintf(os.Stderr, "ssdeep: %s: %v\n", path, err)
+// minFoldedLength is the number of digest characters from which a hash without any
+// lowercase or without any uppercase letter is taken for case-folded. An intact digest
+// character is lowercase, or uppercase, with probability 26/64, so a digest this long
+// lacks one of the cases by chance in fewer than one hash in ten thousand.
+const minFoldedLength = 20
+
+// looksCaseFolded reports whether h appears to have been stored by a system that folds
+// case. Digest characters are case-sensitive, so such a hash no longer compares correctly.
+func looksCaseFolded(h ssdeep.HashInfo) bool {
+	part1, part2 := h.Segments()
+	digest := part1 + part2
+	if len(digest) < minFoldedLength {
+		return false
+	}
+	return digest == strings.ToUpper(digest) || digest == strings.ToLower(digest)
+}
+
+func matchPath(path string, hashes *hashIndex) {
+	visitFiles(path, func(p string, _ fs.FileInfo) {
+		matchFileAgainstHashes(p, hashes)
+	})
+}
+
+// matchFileAgainstHashes hashes the file at path and prints the known files it matches.
+// The caller checks that path is visited for the first time.
+func matchFileAgainstHashes(path string, hashes *hashIndex) {
+	if minSize > 0 {
+		info, err := os.Stat(path)
+		if err != nil {
+			reportError(path, err)
+			return
+		}
+		if info.Size() < minSize {
+			return
 		}
+	}
+
+	hash, err := hashFile(path)
+	if err != nil {
+		reportError(path, err)
 		return
 	}
 
-	for _, h := range hashes {
-		score, err := ssdeep.Compare(hash, h.hash)
-		if err == nil && score > 0 {
-			fmt.Printf("%s matches %s (%d)\n", path, h.path, score)
+	parsed, err := ssdeep.Parse(hash)
+	if err != nil {
+		reportError(path, err)
+		return
+	}
+	succeeded = true
+
+	for _, h := range hashes.candidates(int(parsed.BlockSize)) {
+		score, err := compareHashes(path, hash, h.hash)
+		if err != nil || score == 0 || (firstMatch && score < minScore) {
+			continue
+		}
+		fmt.Fprintf(stdout, "%s matches %s (%d)\n", displayPath(path), displayPath(h.path), score)
+		matched = true
+		if firstMatch {
+			return
 		}
 	}
 }
 
 func processPath(path string) {
+	visitFiles(path, hashAndPrint)
+}
+
+// visitFiles calls visit for path, or for every file under it if it is a directory,
+// skipping the files already processed in this run. info is the result of stat'ing
+// the file if already known, or nil.
+func visitFiles(path string, visit func(path string, info fs.FileInfo)) {
 	info, err := os.Stat(path)
 	if err != nil {
-		if !silent {
-			fmt.Fprintf(os.Stderr, "ssdeep: %s: %v\n", path, err)
-		}
+		reportError(path, err)
 		return
 	}
 
 	if info.IsDir() {
-		filepath.Walk(path, func(p string, i os.FileInfo, e error) error {
+		// WalkDir takes entry types from the directory listing instead of
+		// stat'ing every path; File stats the files it opens anyway
+		first := walkVisitor(path)
+		filepath.WalkDir(walkRoot(path), func(p string, d fs.DirEntry, e error) error {
 			if e != nil {
-				if !silent {
-					fmt.Fprintf(os.Stderr, "ssdeep: %s: %v\n", p, e)
-				}
-				return nil
+				return walkError(p, d, e)
 			}
-			if !i.IsDir() {
-				hashAndPrint(p)
+			if !d.IsDir() && first(p, d) {
+				visit(p, nil)
 			}
 			return nil
 		})
-	} else {
-		hashAndPrint(path)
+	} else if firstVisit(path) {
+		visit(path, info)
+	}
+}
+
+// hashFile hashes a single file, giving up after fileTimeout when it is set
+func hashFile(path string) (string, error) {
+	if fileTimeout <= 0 {
+		return ssdeep.File(path)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), fileTimeout)
+	defer cancel()
+
+	hash, err := ssdeep.FileContext(ctx, path)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "", fmt.Errorf("timed out after %v", fileTimeout)
+	}
+	return hash, err
+}
+
+// firstVisit reports whether path refers to a file not yet processed in this run.
+// Paths are canonicalized so that relative paths and symlinks to the same file
+// are only hashed once, unless --no-dedup is set.
+func firstVisit(path string) bool {
+	if noDedup {
+		return true
+	}
+	canonical, ok := canonicalPath(path)
+	if !ok {
+		return true
 	}
+	return markVisited(canonical)
 }
 
-func hashAndPrint(path string) {
-	hash, err := ssdeep.File(path)
+// walkVisitor returns the firstVisit check of the entries found walking the directory
+// root. Resolving symlinks costs several syscalls per file, so only entries that are
+// symlinks are resolved: WalkDir does not follow them, so the path of any other entry
+// is its path relative to root joined to root resolved once.
+func walkVisitor(root string) func(path string, d fs.DirEntry) bool {
+	if noDedup {
+		return func(string, fs.DirEntry) bool { return true }
+	}
+	canonicalRoot, ok := canonicalPath(root)
+	return func(path string, d fs.DirEntry) bool {
+		rel, err := filepath.Rel(root, path)
+		if !ok || err != nil || d.Type()&fs.ModeSymlink != 0 {
+			return firstVisit(path)
+		}
+		return markVisited(filepath.Join(canonicalRoot, rel))
+	}
+}
+
+// walkRoot returns the path to walk the directory path from. WalkDir does not follow
+// symlinks, not even its root, so a symlink to a directory is walked with a trailing
+// separator, which resolves it while keeping the paths found under the one given.
+func walkRoot(path string) string {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		return path + string(filepath.Separator)
+	}
+	return path
+}
+
+// canonicalPath returns the absolute path of path with symlinks resolved as far as
+// possible, and false if it has no absolute path
+func canonicalPath(path string) (string, bool) {
+	canonical, err := filepath.Abs(path)
 	if err != nil {
-		if !silent {
-			fmt.Fprintf(os.Stderr, "ssdeep: %s: %v\n", path, err)
+		return "", false
+	}
+	if resolved, err := filepath.EvalSymlinks(canonical); err == nil {
+		canonical = resolved
+	}
+	return canonical, true
+}
+
+// markVisited records the canonical path of a file, reporting whether it is new
+func markVisited(canonical string) bool {
+	if seen[canonical] {
+		return false
+	}
+	seen[canonical] = true
+	return true
+}
+
+// hashAndPrint hashes the file at path and prints its record. info is the result of
+// stat'ing path if already known, or nil; it is only needed with --with-meta.
+// The caller checks that path is visited for the first time.
+func hashAndPrint(path string, info fs.FileInfo) {
+	var err error
+	if withMeta || minSize > 0 {
+		if info, err = statIfNil(path, info); err != nil {
+			reportError(path, err)
+			return
+		}
+		if info.Size() < minSize {
+			return
 		}
+	}
+
+	hash, err := hashFile(path)
+	if err != nil {
+		reportError(path, err)
 		return
 	}
-	fmt.Printf("%s,\"%s\"\n", hash, path)
+
+	var meta string
+	if withMeta {
+		meta = metaFields(info)
+	}
+	succeeded = true
+
+	r := hashInfo{hash: hash, path: path, meta: meta}
+	if dedupMode != "" {
+		results = append(results, r)
+		return
+	}
+	printRecord(r)
 }
 
-func main() {
-	rootCmd.Flags().BoolVarP(&silent, "silent", "s", false, "silent mode - suppresses error messages")
-	rootCmd.Flags().StringVarP(&matchFile, "match", "m", "", "match files against hashes in file")
+// statIfNil returns info, or the result of stat'ing path if info is nil. Walked entries
+// are not stat'ed, and their entry info describes symlinks themselves rather than the
+// files they point to, which are the ones hashed.
+func statIfNil(path string, info fs.FileInfo) (fs.FileInfo, error) {
+	if info != nil {
+		return info, nil
+	}
+	return os.Stat(path)
+}
+
+// printRecord prints r as an output line, or only its hash with --hash-only
+func printRecord(r hashInfo) {
+	if hashOnly {
+		fmt.Fprintln(stdout, r.hash)
+		return
+	}
+	fmt.Fprintf(stdout, "%s,\"%s\"%s\n", r.hash, displayPath(r.path), r.meta)
+}
+
+// printDeduplicated prints the first path seen for each hash, suppressing later hashes that
+// score 100 against an earlier one, or at least --min-score in fuzzy mode, so that only one
+// representative of each cluster of near-duplicates is printed
+func printDeduplicated(results []hashInfo) {
+	threshold := 100
+	if dedupMode == dedupFuzzy {
+		threshold = minScore
+	}
+
+	printed := newHashIndex()
+	for _, r := range results {
+		h, err := ssdeep.Parse(r.hash)
+		if err != nil {
+			continue
+		}
+		if isDuplicate(r.hash, printed.candidates(int(h.BlockSize)), threshold) {
+			continue
+		}
+		printed.add(h, r)
+		printed.lines++
+		printRecord(r)
+	}
+}
+
+// isDuplicate reports whether hash scores at least threshold against one of hashes
+func isDuplicate(hash string, hashes []hashInfo, threshold int) bool {
+	for _, h := range hashes {
+		score, err := ssdeep.Compare(hash, h.hash)
+		if err == nil && score >= threshold {
+			return true
+		}
+	}
+	return false
+}
+
+// foldPathCase reports whether the default filesystems of the platform ignore case
+var foldPathCase = runtime.GOOS == "windows" || runtime.GOOS == "darwin"
+
+// displayPath returns path as printed, normalized when --normalize-paths is set
+func displayPath(path string) string {
+	if !normalize {
+		return path
+	}
+	return normalizePath(path, foldPathCase)
+}
+
+// normalizePath cleans path and uses forward slashes as separators, so that the
+// same file is printed the same way across runs. With foldCase it is also
+// lowercased, for filesystems where paths differing in case name the same file.
+func normalizePath(path string, foldCase bool) string {
+	path = filepath.ToSlash(filepath.Clean(path))
+	if foldCase {
+		path = strings.ToLower(path)
+	}
+	return path
+}
+
+func init() {
+	rootCmd.PersistentFlags().BoolVarP(&silent, "silent", "s", false, "silent mode - suppresses error messages")
+	rootCmd.PersistentFlags().DurationVar(&fileTimeout, "file-timeout", 0, "skip files that take longer than this to hash (0 disables)")
+	rootCmd.PersistentFlags().BoolVar(&normalize, "normalize-paths", false, "print cleaned paths with forward slashes, lowercased on case-insensitive platforms")
+	rootCmd.PersistentFlags().BoolVar(&compat, "compat", false, "use the flag-based interface of earlier versions: ssdeep --compat [-m file] [options] files (deprecated)")
+
+	// The flags of the earlier interface, hidden since the subcommands replace them
+	addInputFlags(rootCmd.Flags())
+	addHashFlags(rootCmd.Flags())
+	addMatchFlags(rootCmd.Flags(), "match")
+	rootCmd.Flags().IntVar(&minScore, "min-score", 90, "with --deduplicate=fuzzy, drop files scoring at least this against a printed one; with --first-match, the score that ends matching")
+	rootCmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if f.Name != "compat" {
+			f.Hidden = true
+		}
+	})
+
+	rootCmd.Version = Version
+	rootCmd.SetVersionTemplate(fmt.Sprintf("ssdeep version {{.Version}} (algorithm: %s compatible)\n", ssdeep.AlgorithmVersion()))
 
 	rootCmd.SetUsageTemplate(`Usage: {{if .Runnable}}{{.UseLine}}{{end}} {{if gt (len .Aliases) 0}}
 
@@ -182,9 +684,13 @@ Additional help topics:{{range .Commands}}{{if .IsAdditionalHelpTopicCommand}}
 
 Use "{{.CommandPath}} [command] --help" for more information about a command.{{end}}
 `)
+}
 
-	if err := rootCmd.Execute(); err != nil {
+func main() {
+	if cmd, err := rootCmd.ExecuteC(); err != nil {
 		fmt.Println(err)
-		os.Exit(1)
+		os.Exit(failedStatus(cmd))
 	}
+
+	os.Exit(exitCode())
 }
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/cosmorse/ssdeep"
+	"github.com/stretchr/testify/require"
+)
+
+// stalledFIFO returns a named pipe whose writer stalls after a few bytes, so that
+// reading it never reaches EOF
+func stalledFIFO(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "fifo")
+	require.NoError(t, syscall.Mkfifo(path, 0o600))
+
+	stall := make(chan struct{})
+	t.Cleanup(func() { close(stall) })
+	go func() {
+		w, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			return
+		}
+		defer w.Close()
+		w.Write([]byte("The quick brown fox"))
+		<-stall
+	}()
+	return path
+}
+
+func TestFileTimeout(t *testing.T) {
+	const sample = "../../testdata/sample1.txt"
+
+	fifo := stalledFIFO(t)
+	out, errOut := run(t, "hash", "--file-timeout", "200ms", fifo, sample)
+	require.Equal(t, "ssdeep: "+fifo+": timed out after 200ms\n", errOut)
+	require.Contains(t, out, `,"`+sample+`"`)
+	require.NotContains(t, out, fifo)
+	require.Equal(t, 1, exitCode())
+
+	// A timeout is an error of match mode even when another file matched
+	hash, err := ssdeep.File(sample)
+	require.NoError(t, err)
+	hashes := filepath.Join(t.TempDir(), "hashes.txt")
+	require.NoError(t, os.WriteFile(hashes, []byte(hash+`,"sample1.txt"`+"\n"), 0o600))
+
+	fifo = stalledFIFO(t)
+	out, errOut = run(t, "match", "--database", hashes, "--file-timeout", "200ms", fifo, sample)
+	require.Equal(t, "ssdeep: "+fifo+": timed out after 200ms\n", errOut)
+	require.Equal(t, sample+" matches sample1.txt (100)\n", out)
+	require.Equal(t, exitError, exitCode())
+}
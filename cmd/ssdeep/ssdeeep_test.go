@@ -0,0 +1,683 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cosmorse/ssdeep"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/require"
+)
+
+// run executes the root command with args and returns what it wrote to stdout and stderr
+func run(t *testing.T, args ...string) (string, string) {
+	t.Helper()
+
+	var out, errOut bytes.Buffer
+	stdout, stderr = &out, &errOut
+	rootCmd.SetOut(&out)
+	t.Cleanup(func() {
+		stdout, stderr = os.Stdout, os.Stderr
+		rootCmd.SetOut(nil)
+	})
+
+	resetFlags()
+	rootCmd.SetArgs(args)
+	require.NoError(t, rootCmd.Execute())
+	return out.String(), errOut.String()
+}
+
+// resetFlags restores the default values of all flags, which keep their values
+// between executions
+func resetFlags() {
+	reset := func(f *pflag.Flag) {
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			sv.Replace(nil)
+		} else {
+			f.Value.Set(f.DefValue)
+		}
+		f.Changed = false
+	}
+	rootCmd.Flags().VisitAll(reset)
+	rootCmd.PersistentFlags().VisitAll(reset)
+	for _, cmd := range rootCmd.Commands() {
+		cmd.Flags().VisitAll(reset)
+	}
+}
+
+// sampleText is the content of the file written by writeSample
+const sampleText = "The quick brown fox jumps over the lazy dog"
+
+// writeSample writes sampleText to sample.txt in a new temporary directory,
+// returning the directory and the path of the file
+func writeSample(t *testing.T) (dir, path string) {
+	t.Helper()
+
+	dir = t.TempDir()
+	path = filepath.Join(dir, "sample.txt")
+	require.NoError(t, os.WriteFile(path, []byte(sampleText), 0o600))
+	return dir, path
+}
+
+func TestDedupPaths(t *testing.T) {
+	dir, path := writeSample(t)
+	link := filepath.Join(dir, "link.txt")
+	require.NoError(t, os.Symlink(path, link))
+
+	out, _ := run(t, "hash", path, path, link)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 1)
+	require.Equal(t, `3:FJKKIUKact:FHIGi,"`+path+`"`, lines[0])
+
+	out, _ = run(t, "hash", "--no-dedup", path, path)
+	require.Len(t, strings.Split(strings.TrimSpace(out), "\n"), 2)
+
+	// Walks resolve the symlinks they find, and the directory they start from
+	out, _ = run(t, "hash", dir)
+	require.Len(t, strings.Split(strings.TrimSpace(out), "\n"), 1)
+	linkDir := filepath.Join(t.TempDir(), "linkdir")
+	require.NoError(t, os.Symlink(dir, linkDir))
+	out, _ = run(t, "hash", path, linkDir)
+	require.Equal(t, `3:FJKKIUKact:FHIGi,"`+path+`"`, strings.TrimSpace(out))
+}
+
+func TestFromStdin(t *testing.T) {
+	dir := t.TempDir()
+
+	var paths []string
+	for i := range 10 {
+		path := filepath.Join(dir, fmt.Sprintf("file %d.txt", i))
+		require.NoError(t, os.WriteFile(path, []byte(fmt.Sprintf("The quick brown fox jumps over the lazy dog %d", i)), 0o600))
+		paths = append(paths, path)
+	}
+
+	for name, input := range map[string]string{
+		"newline": strings.Join(paths, "\r\n") + "\n",
+		"nul":     strings.Join(paths, "\x00"),
+	} {
+		stdin = strings.NewReader(input)
+		out, _ := run(t, "hash", "--from-stdin")
+		stdin = os.Stdin
+
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		require.Len(t, lines, len(paths), name)
+		for i, path := range paths {
+			require.True(t, strings.HasSuffix(lines[i], `,"`+path+`"`), "%s: %s", name, lines[i])
+		}
+	}
+}
+
+func TestWalkUnreadableSubdirectory(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can read directories regardless of permissions")
+	}
+
+	dir := t.TempDir()
+	for _, name := range []string{"a", "locked", "z"} {
+		sub := filepath.Join(dir, name)
+		require.NoError(t, os.Mkdir(sub, 0o700))
+		require.NoError(t, os.WriteFile(filepath.Join(sub, "file.txt"), []byte(sampleText), 0o600))
+	}
+	locked := filepath.Join(dir, "locked")
+	require.NoError(t, os.Chmod(locked, 0))
+	t.Cleanup(func() { os.Chmod(locked, 0o700) })
+
+	out, errOut := run(t, "hash", dir)
+	require.Contains(t, out, filepath.Join(dir, "a", "file.txt"))
+	require.Contains(t, out, filepath.Join(dir, "z", "file.txt"))
+	require.NotContains(t, out, locked)
+	require.Contains(t, errOut, locked)
+	require.Equal(t, 1, exitCode())
+}
+
+func TestMissingFileFails(t *testing.T) {
+	_, errOut := run(t, "hash", filepath.Join(t.TempDir(), "missing"))
+	require.Contains(t, errOut, "no such file")
+	require.Equal(t, 2, exitCode())
+
+	// --silent hides the message but keeps the status
+	_, errOut = run(t, "hash", "-s", filepath.Join(t.TempDir(), "missing"))
+	require.Empty(t, errOut)
+	require.Equal(t, 2, exitCode())
+
+	run(t, "hash", "-s", filepath.Join(t.TempDir(), "missing"), "../../testdata/sample1.txt")
+	require.Equal(t, 1, exitCode())
+
+	run(t, "hash", "../../testdata/sample1.txt")
+	require.Equal(t, 0, exitCode())
+}
+
+// TestExitStatus runs the CLI in a child process to check the status main exits with
+func TestExitStatus(t *testing.T) {
+	if args, ok := os.LookupEnv("SSDEEP_TEST_MAIN_ARGS"); ok {
+		os.Args = []string{"ssdeep"}
+		if args != "" {
+			os.Args = append(os.Args, strings.Split(args, "\n")...)
+		}
+		main()
+		return
+	}
+
+	missing := filepath.Join(t.TempDir(), "missing")
+	sample, err := ssdeep.File("../../testdata/sample1.txt")
+	require.NoError(t, err)
+	hashes := filepath.Join(t.TempDir(), "hashes.txt")
+	require.NoError(t, os.WriteFile(hashes, []byte(sample+`,"sample1.txt"`+"\n"), 0o600))
+
+	for _, tc := range []struct {
+		args   []string
+		status int
+	}{
+		{[]string{"hash", "../../testdata/sample1.txt"}, 0},
+		{[]string{"hash", "-s", missing, "../../testdata/sample1.txt"}, 1},
+		{[]string{"hash", missing}, 2},
+		{[]string{"hash", "-s", missing}, 2},
+		// Match mode: 0 if something matched, 1 if nothing did, 2 on errors
+		{[]string{"match", "--database", hashes, "../../testdata/sample1.txt"}, 0},
+		{[]string{"match", "--database", hashes, "../../testdata/sample.dat"}, 1},
+		{[]string{"match", "-s", "--database", hashes, missing, "../../testdata/sample1.txt"}, 2},
+		{[]string{"match", "-s", "--database", missing, "../../testdata/sample1.txt"}, 2},
+		{[]string{"match", "../../testdata/sample1.txt"}, 2},
+		{[]string{"match", "--database", hashes, "--no-such-flag", "../../testdata/sample1.txt"}, 2},
+		// The earlier interface keeps its statuses with --compat
+		{[]string{"--compat", "-m", hashes, "../../testdata/sample.dat"}, 1},
+		{[]string{"--compat", "-s", missing}, 2},
+		// Files without a command are an error, no command at all prints help
+		{[]string{"../../testdata/sample1.txt"}, 1},
+		{[]string{}, 0},
+	} {
+		require.Equal(t, tc.status, runMain(t, nil, tc.args...), "%v", tc.args)
+	}
+
+	// Reading file names fails with a directory as stdin
+	dir, err := os.Open(t.TempDir())
+	require.NoError(t, err)
+	defer dir.Close()
+	require.Equal(t, 1, runMain(t, dir, "hash", "--from-stdin"))
+	require.Equal(t, 2, runMain(t, dir, "match", "--database", hashes, "--from-stdin"))
+}
+
+// runMain runs main with args in a child process reading stdin, and returns its exit status
+func runMain(t *testing.T, stdin *os.File, args ...string) int {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestExitStatus$")
+	cmd.Env = append(os.Environ(), "SSDEEP_TEST_MAIN_ARGS="+strings.Join(args, "\n"))
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+	err := cmd.Run()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	require.NoError(t, err)
+	return 0
+}
+
+func BenchmarkWalkDir(b *testing.B) {
+	dir := b.TempDir()
+	for i := range 10000 {
+		sub := filepath.Join(dir, fmt.Sprintf("%02d", i%100))
+		if i < 100 {
+			require.NoError(b, os.Mkdir(sub, 0o700))
+		}
+		path := filepath.Join(sub, fmt.Sprintf("file%05d.txt", i))
+		require.NoError(b, os.WriteFile(path, []byte(fmt.Sprintf("The quick brown fox jumps over the lazy dog %d", i)), 0o600))
+	}
+
+	stdout, stderr = io.Discard, io.Discard
+	b.Cleanup(func() {
+		stdout, stderr = os.Stdout, os.Stderr
+	})
+
+	// Deduplication only resolves the walked directory, not each file in it
+	b.Run("Dedup", func(b *testing.B) {
+		for b.Loop() {
+			clear(seen)
+			processPath(dir)
+		}
+	})
+	b.Run("NoDedup", func(b *testing.B) {
+		noDedup = true
+		defer func() { noDedup = false }()
+		for b.Loop() {
+			processPath(dir)
+		}
+	})
+}
+
+func TestMatchBuckets(t *testing.T) {
+	dir, path := writeSample(t)
+
+	// Tens of thousands of hashes, of which only block sizes 3 and 6 are compatible with the sample's 3
+	var list strings.Builder
+	blockSizes := []int{3, 6, 12, 24, 48, 96}
+	for i := range 30000 {
+		fmt.Fprintf(&list, "%d:FJKKIUKac%d:FHIGi,\"file%d\"\n", blockSizes[i%len(blockSizes)], i, i)
+	}
+	list.WriteString("3:FJKKIUKact:FHIGi,\"match\"\n")
+	list.WriteString("not a hash,\"broken\"\n")
+	hashFile := filepath.Join(dir, "hashes.txt")
+	require.NoError(t, os.WriteFile(hashFile, []byte(list.String()), 0o600))
+
+	idx, err := loadHashes(hashFile)
+	require.NoError(t, err)
+	candidates := idx.candidates(3)
+	require.Len(t, candidates, 10001)
+	for i, c := range candidates {
+		require.Contains(t, []int{3, 6}, blockSizeOf(t, c.hash))
+		if i > 0 {
+			require.Less(t, candidates[i-1].line, c.line, "candidates should keep file order")
+		}
+	}
+	require.Len(t, idx.candidates(24), 15000)
+
+	out, _ := run(t, "match", "-m", hashFile, path)
+	require.Contains(t, out, path+" matches match (100)")
+}
+
+func TestMatchWrittenHashFile(t *testing.T) {
+	dir, path := writeSample(t)
+
+	// Hash files written by the library read back with their file names intact
+	h, err := ssdeep.Parse("3:FJKKIUKact:FHIGi")
+	require.NoError(t, err)
+	names := []string{"plain.txt", `say "hi".txt`, `trailing".txt"`, "comma, space.txt", ""}
+	var entries []ssdeep.Record
+	for _, name := range names {
+		entries = append(entries, ssdeep.Record{Hash: h}.WithMeta("path", name))
+	}
+	hashFile := filepath.Join(dir, "hashes.txt")
+	require.NoError(t, ssdeep.WriteHashFile(hashFile, entries))
+
+	idx, err := loadHashes(hashFile)
+	require.NoError(t, err)
+	var loaded []string
+	for _, c := range idx.candidates(3) {
+		require.Equal(t, h.String(), c.hash)
+		loaded = append(loaded, c.path)
+	}
+	require.Equal(t, names, loaded)
+
+	out, _ := run(t, "match", "--database", hashFile, path)
+	for _, name := range names {
+		require.Contains(t, out, path+" matches "+name+" (100)\n")
+	}
+
+	// A name that would split its record is refused instead
+	err = ssdeep.WriteHashFile(hashFile, []ssdeep.Record{{Hash: h, Metadata: map[string]string{"path": "two\nlines.txt"}}})
+	require.ErrorIs(t, err, ssdeep.ErrInvalidFileName)
+}
+
+func TestMatchSeveralFiles(t *testing.T) {
+	dir, path := writeSample(t)
+
+	good := filepath.Join(dir, "good.txt")
+	require.NoError(t, os.WriteFile(good, []byte("3:FJKKIUKact:FHIGi,\"known-good\"\n"), 0o600))
+	bad := filepath.Join(dir, "bad.txt")
+	require.NoError(t, os.WriteFile(bad, []byte("3:FJKKIrKact:FHIrGi,\"known-bad\"\n3:FJKKIUKact:FHIGi,\"known-good\"\n"), 0o600))
+
+	for _, args := range [][]string{
+		{"-m", good, "-m", bad, path},
+		{"-m", good + "," + bad, path},
+	} {
+		out, _ := run(t, append([]string{"match"}, args...)...)
+		require.Equal(t, path+" matches known-good (100)\n"+path+" matches known-bad (71)\n", out, "%v", args)
+	}
+}
+
+// blockSizeOf returns the block size of hash
+func blockSizeOf(t *testing.T, hash string) int {
+	h, err := ssdeep.Parse(hash)
+	require.NoError(t, err)
+	return int(h.BlockSize)
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	unchanged := filepath.Join(dir, "unchanged.txt")
+	require.NoError(t, os.WriteFile(unchanged, []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 200)), 0o600))
+	modified := filepath.Join(dir, "modified.txt")
+	require.NoError(t, os.WriteFile(modified, []byte(strings.Repeat("Sphinx of black quartz, judge my vow! ", 200)), 0o600))
+
+	out, _ := run(t, "hash", unchanged, modified)
+	baseline := filepath.Join(dir, "baseline.txt")
+	require.NoError(t, os.WriteFile(baseline, []byte(out), 0o600))
+
+	out, _ = run(t, "verify", baseline)
+	require.Equal(t, unchanged+": 100 ok\n"+modified+": 100 ok\n", out)
+	require.Equal(t, 0, exitCode())
+
+	require.NoError(t, os.WriteFile(modified, []byte(strings.Repeat("Pack my box with five dozen liquor jugs. ", 200)), 0o600))
+	out, _ = run(t, "verify", baseline)
+	require.Contains(t, out, unchanged+": 100 ok\n")
+	require.Regexp(t, regexp.QuoteMeta(modified)+`: \d+ changed\n`, out)
+	require.Equal(t, 1, exitCode())
+
+	// A lower threshold tolerates the drift, a missing file is still an error
+	require.NoError(t, os.Remove(unchanged))
+	out, errOut := run(t, "verify", "-t", "0", baseline)
+	require.Regexp(t, regexp.QuoteMeta(modified)+`: \d+ ok\n`, out)
+	require.Contains(t, errOut, unchanged)
+	require.Equal(t, 1, exitCode())
+}
+
+func TestNormalizePath(t *testing.T) {
+	for _, tc := range []struct {
+		path     string
+		foldCase bool
+		want     string
+	}{
+		{"dir/File.TXT", false, "dir/File.TXT"},
+		{"dir/File.TXT", true, "dir/file.txt"},
+		{"./dir//Sub/../File.txt", false, "dir/File.txt"},
+		{"/Data/./Evidence/", true, "/data/evidence"},
+		{filepath.Join("C", "Users", "Alice", "Doc.pdf"), true, "c/users/alice/doc.pdf"},
+	} {
+		require.Equal(t, tc.want, normalizePath(tc.path, tc.foldCase), "%q", tc.path)
+	}
+
+	out, _ := run(t, "hash", "--normalize-paths", "../../testdata/./sample1.txt")
+	require.True(t, strings.HasSuffix(out, `,"../../testdata/sample1.txt"`+"\n"), out)
+}
+
+func TestDeduplicate(t *testing.T) {
+	dir := t.TempDir()
+	var text strings.Builder
+	for i := range 300 {
+		fmt.Fprintf(&text, "line %d of the quick brown fox jumps over the lazy dog\n", i)
+	}
+	files := map[string]string{
+		"a.txt": text.String(),
+		"b.txt": text.String(), // Copy of a
+		"c.txt": strings.Replace(text.String(), "line 150 ", "line 15O ", 1),
+		"d.txt": strings.Repeat("Sphinx of black quartz, judge my vow! ", 300),
+	}
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+	}
+
+	printed := func(out string) []string {
+		var names []string
+		for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+			_, path, _ := strings.Cut(line, ",")
+			names = append(names, filepath.Base(strings.Trim(path, `"`)))
+		}
+		return names
+	}
+
+	out, _ := run(t, "hash", dir)
+	require.Equal(t, []string{"a.txt", "b.txt", "c.txt", "d.txt"}, printed(out))
+
+	out, _ = run(t, "hash", "--deduplicate", dir)
+	require.Equal(t, []string{"a.txt", "c.txt", "d.txt"}, printed(out))
+
+	out, _ = run(t, "hash", "-d", dir)
+	require.Equal(t, []string{"a.txt", "c.txt", "d.txt"}, printed(out))
+
+	out, _ = run(t, "hash", "--deduplicate=fuzzy", "--min-score=90", dir)
+	require.Equal(t, []string{"a.txt", "d.txt"}, printed(out))
+
+	rootCmd.SetErr(io.Discard)
+	defer rootCmd.SetErr(nil)
+	rootCmd.SetArgs([]string{"hash", "--deduplicate=other", dir})
+	require.Error(t, rootCmd.Execute())
+}
+
+func TestWithMeta(t *testing.T) {
+	dir, path := writeSample(t)
+	mtime := time.Date(2024, 3, 1, 12, 30, 45, 500, time.UTC)
+	require.NoError(t, os.Chtimes(path, mtime, mtime))
+
+	want := `3:FJKKIUKact:FHIGi,"` + path + `",43,2024-03-01T12:30:45.0000005Z` + "\n"
+	for _, arg := range []string{path, dir} {
+		out, _ := run(t, "hash", "--with-meta", arg)
+		require.Equal(t, want, out, arg)
+	}
+
+	hash, p, ok := parseRecord(strings.TrimSpace(want))
+	require.True(t, ok)
+	require.Equal(t, "3:FJKKIUKact:FHIGi", hash)
+	require.Equal(t, path, p)
+
+	// Records with metadata are valid baselines
+	baseline := filepath.Join(dir, "baseline.csv")
+	require.NoError(t, os.WriteFile(baseline, []byte(want), 0o600))
+	out, _ := run(t, "verify", baseline)
+	require.Equal(t, path+": 100 ok\n", out)
+}
+
+func TestHashOnly(t *testing.T) {
+	dir, path := writeSample(t)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "copy.txt"), []byte(sampleText), 0o600))
+
+	out, _ := run(t, "hash", "--hash-only", path)
+	require.Equal(t, "3:FJKKIUKact:FHIGi\n", out)
+	require.NotContains(t, out, path)
+
+	out, _ = run(t, "hash", "--hash-only", dir)
+	require.Equal(t, "3:FJKKIUKact:FHIGi\n3:FJKKIUKact:FHIGi\n", out)
+	out, _ = run(t, "hash", "--hash-only", "-d", dir)
+	require.Equal(t, "3:FJKKIUKact:FHIGi\n", out)
+
+	// The default format is unchanged
+	out, _ = run(t, "hash", path)
+	require.Equal(t, `3:FJKKIUKact:FHIGi,"`+path+`"`+"\n", out)
+
+	rootCmd.SetErr(io.Discard)
+	defer rootCmd.SetErr(nil)
+	rootCmd.SetArgs([]string{"hash", "--hash-only", "--with-meta", path})
+	require.Error(t, rootCmd.Execute())
+}
+
+func TestMinSize(t *testing.T) {
+	dir, path := writeSample(t)
+	tiny := filepath.Join(dir, "tiny.txt")
+	require.NoError(t, os.WriteFile(tiny, []byte("fox"), 0o600))
+
+	out, _ := run(t, "hash", dir)
+	require.Contains(t, out, tiny)
+
+	for _, args := range [][]string{
+		{"--min-size", "32", dir},
+		{"--min-size", "32", tiny, path},
+	} {
+		out, _ := run(t, append([]string{"hash"}, args...)...)
+		require.Equal(t, `3:FJKKIUKact:FHIGi,"`+path+`"`+"\n", out, "%v", args)
+		require.Equal(t, 0, exitCode())
+	}
+
+	// Files of exactly the minimum size are kept
+	out, _ = run(t, "hash", "--min-size", "3", tiny)
+	require.Contains(t, out, tiny)
+
+	hashes := filepath.Join(t.TempDir(), "hashes.txt")
+	require.NoError(t, os.WriteFile(hashes, []byte(`3:FJKKIUKact:FHIGi,"known"`+"\n"+`3:a:a,"tiny"`+"\n"), 0o600))
+	out, _ = run(t, "match", "--min-size", "32", "-m", hashes, dir)
+	require.Equal(t, path+" matches known (100)\n", out)
+}
+
+func TestFirstMatch(t *testing.T) {
+	dir, path := writeSample(t)
+	hashes := filepath.Join(dir, "hashes.txt")
+	require.NoError(t, os.WriteFile(hashes, []byte(`3:FJKKIrKact:FHIrGi,"similar"`+"\n"+
+		`3:FJKKIUKact:FHIGi,"known"`+"\n"+`3:FJKKIUKact:FHIGi,"copy"`+"\n"), 0o600))
+
+	out, _ := run(t, "match", "-m", hashes, path)
+	require.Equal(t, 3, strings.Count(out, " matches "))
+
+	out, _ = run(t, "match", "--first-match", "-m", hashes, path)
+	require.Equal(t, path+" matches known (100)\n", out)
+	require.Equal(t, 0, exitCode())
+
+	// The first match at or above --min-score ends matching, weaker ones are not printed
+	out, _ = run(t, "match", "--first-match", "--min-score", "50", "-m", hashes, path)
+	require.Equal(t, path+" matches similar (71)\n", out)
+}
+
+func TestCompareCommand(t *testing.T) {
+	var text strings.Builder
+	for i := range 300 {
+		fmt.Fprintf(&text, "line %d of the quick brown fox jumps over the lazy dog\n", i)
+	}
+	dir1, dir2 := t.TempDir(), t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir1, "a.txt"), []byte(text.String()), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir1, "b.txt"), []byte(strings.Repeat("Sphinx of black quartz, judge my vow! ", 300)), 0o600))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir2, "sub"), 0o700))
+	edited := strings.Replace(text.String(), "line 150 ", "line 15O ", 1)
+	require.NoError(t, os.WriteFile(filepath.Join(dir2, "sub", "c.txt"), []byte(edited), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir2, "d.txt"), []byte(sampleText), 0o600))
+
+	a, c := filepath.Join(dir1, "a.txt"), filepath.Join(dir2, "sub", "c.txt")
+	out, _ := run(t, "compare", dir1, dir2)
+	require.Equal(t, a+" <-> "+c+" (99)\n", out)
+	require.Equal(t, 0, exitCode())
+
+	out, _ = run(t, "compare", "--format", "json", dir1, dir2)
+	var pair comparePair
+	require.NoError(t, json.Unmarshal([]byte(out), &pair))
+	require.Equal(t, comparePair{Path1: a, Path2: c, Score: 99}, pair)
+
+	// Nothing scores 100 across the directories
+	out, _ = run(t, "compare", "--min-score", "100", dir1, dir2)
+	require.Empty(t, out)
+
+	_, errOut := run(t, "compare", dir1, filepath.Join(dir2, "missing"))
+	require.Contains(t, errOut, "missing")
+	require.Equal(t, 1, exitCode())
+
+	// Files are selected like hash selects them: a symlink to a.txt is hashed once,
+	// unless --no-dedup is set, and --min-size skips smaller files
+	require.NoError(t, os.Symlink(a, filepath.Join(dir1, "link.txt")))
+	out, _ = run(t, "compare", dir1, dir2)
+	require.Equal(t, a+" <-> "+c+" (99)\n", out)
+	out, _ = run(t, "compare", "--no-dedup", dir1, dir2)
+	require.Contains(t, out, "link.txt")
+	out, _ = run(t, "compare", "--min-size", strconv.Itoa(len(text.String())+1), dir1, dir2)
+	require.Empty(t, out)
+	out, _ = run(t, "compare", "--file-timeout", "1m", dir1, dir2)
+	require.Equal(t, a+" <-> "+c+" (99)\n", out)
+
+	// A failed write is an error in both formats
+	for _, f := range []string{formatText, formatJSON} {
+		resetFlags()
+		stdout, stderr = failingWriter{}, io.Discard
+		rootCmd.SetArgs([]string{"compare", "--format", f, dir1, dir2})
+		require.NoError(t, rootCmd.Execute())
+		stdout, stderr = os.Stdout, os.Stderr
+		require.Equal(t, 1, exitCode(), f)
+	}
+
+	rootCmd.SetErr(io.Discard)
+	defer rootCmd.SetErr(nil)
+	for _, args := range [][]string{
+		{"compare", "--format", "xml", dir1, dir2},
+		{"compare", dir1},
+		{"compare", "-d", dir1, dir2},
+		{"hash", "--compare-all", dir1, dir2},
+	} {
+		rootCmd.SetArgs(args)
+		require.Error(t, rootCmd.Execute(), "%v", args)
+	}
+}
+
+// failingWriter is a Writer whose writes all fail, like a closed pipe
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func TestWarnCase(t *testing.T) {
+	dir, path := writeSample(t)
+
+	const hash = "48:QHpZw8tyCJmIrhLb3ZY5SgqHT2lpxW7bRAiyDRfBmW:QpZwrUIrhLb5Y5SgqHT2lpxW7bRAiyDq"
+	hashes := filepath.Join(dir, "hashes.txt")
+	require.NoError(t, os.WriteFile(hashes, []byte(
+		`3:FJKKIUKact:FHIGi,"known"`+"\n"+
+			hash+`,"intact"`+"\n"+
+			strings.ToUpper(hash)+`,"upper"`+"\n"+
+			strings.ToLower(hash)+`,"lower"`+"\n"), 0o600))
+
+	_, errOut := run(t, "match", "-m", hashes, path)
+	require.Empty(t, errOut)
+
+	out, errOut := run(t, "match", "--warn-case", "-m", hashes, path)
+	require.Equal(t, path+" matches known (100)\n", out)
+	require.Equal(t, "ssdeep: warning: "+hashes+": 2 hashes look case-folded, starting at line 3; comparisons against them are unreliable\n", errOut)
+	require.Equal(t, 0, exitCode())
+
+	_, errOut = run(t, "match", "--warn-case", "-s", "-m", hashes, path)
+	require.Empty(t, errOut)
+
+	// Short digests often lack a case, so they are not flagged
+	require.False(t, looksCaseFolded(ssdeep.HashInfo{BlockSize: 3, Part1: "FJKKIUKACT", Part2: "FHIGI"}))
+}
+
+func TestVerifyIncompatibleBlockSizes(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "grown.txt")
+	require.NoError(t, os.WriteFile(path, []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 1000)), 0o600))
+
+	// The baseline was taken when the file was tiny, at a block size too far off to compare
+	baseline := filepath.Join(dir, "baseline.txt")
+	require.NoError(t, os.WriteFile(baseline, []byte(`3:FJKKIUKact:FHIGi,"`+path+`"`+"\n"), 0o600))
+
+	out, errOut := run(t, "verify", baseline)
+	require.Equal(t, path+": 0 changed\n", out)
+	require.Contains(t, errOut, "warning")
+	require.Contains(t, errOut, ssdeep.ErrIncompatibleBlockSizes.Error())
+	require.Equal(t, 1, exitCode())
+
+	_, errOut = run(t, "verify", "-s", baseline)
+	require.Empty(t, errOut)
+}
+
+func TestCompat(t *testing.T) {
+	dir, path := writeSample(t)
+	hashes := filepath.Join(dir, "hashes.txt")
+	require.NoError(t, os.WriteFile(hashes, []byte(`3:FJKKIUKact:FHIGi,"known"`+"\n"), 0o600))
+
+	// Without a command the root command only prints help
+	out, _ := run(t)
+	require.Contains(t, out, "Commands:")
+	require.Contains(t, out, "match")
+	require.NotContains(t, out, "--deduplicate")
+
+	// The earlier interface still works with --compat, flags included
+	want, _ := run(t, "hash", "--hash-only", path)
+	out, _ = run(t, "--compat", "--hash-only", path)
+	require.Equal(t, want, out)
+	out, _ = run(t, "--compat", "hash", "--hash-only", path)
+	require.Equal(t, want, out)
+
+	want, _ = run(t, "match", "--database", hashes, path)
+	require.Equal(t, path+" matches known (100)\n", want)
+	out, _ = run(t, "--compat", "-m", hashes, path)
+	require.Equal(t, want, out)
+	require.Equal(t, exitMatch, exitCode())
+
+	rootCmd.SetErr(io.Discard)
+	defer rootCmd.SetErr(nil)
+	for _, args := range [][]string{
+		{path},
+		{"-m", hashes},
+		{"match", path},
+		{"hash", "-m", hashes, path},
+	} {
+		resetFlags()
+		rootCmd.SetArgs(args)
+		require.Error(t, rootCmd.Execute(), "%v", args)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// compat selects the flag-based interface of earlier versions on the root command,
+// where -m switched to match mode; it is kept for one major version
+var compat bool
+
+var hashCmd = &cobra.Command{
+	Use:   "hash [options] files",
+	Short: "compute the fuzzy hashes of files and directories",
+	Long: "hash prints the fuzzy hash of every file given, walking directories recursively, in the format\n" +
+		"read by match and verify.",
+	Args:                  validateArgs,
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		runFiles(args)
+	},
+}
+
+var matchCmd = &cobra.Command{
+	Use:   "match --database file [options] files",
+	Short: "match files against the hashes of known files",
+	Long: "match hashes every file given, walking directories recursively, and prints the known files of\n" +
+		"the --database hash files it is similar to. It exits with 0 if something matched, 1 if nothing\n" +
+		"did and 2 on errors.",
+	Args:                  validateArgs,
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		runFiles(args)
+	},
+}
+
+// validateRootArgs accepts the arguments of the earlier interface with --compat.
+// Without it the root command only prints help, and rejects files and mode flags
+// with a pointer to the subcommand replacing them.
+func validateRootArgs(cmd *cobra.Command, args []string) error {
+	if compat {
+		return validateArgs(cmd, args)
+	}
+	modeFlags := false
+	cmd.LocalNonPersistentFlags().VisitAll(func(f *pflag.Flag) {
+		modeFlags = modeFlags || f.Changed
+	})
+	if len(args) > 0 || modeFlags {
+		return errors.New(`files are processed by a command: use "ssdeep hash" or "ssdeep match --database", or --compat for the earlier interface`)
+	}
+	return nil
+}
+
+// addWalkFlags registers the flags selecting the files processed under the paths given
+func addWalkFlags(flags *pflag.FlagSet) {
+	flags.BoolVar(&noDedup, "no-dedup", false, "hash files again when several paths resolve to the same file")
+	flags.Int64Var(&minSize, "min-size", 0, "skip files smaller than this many bytes, whose hashes are too short to match (0 hashes all files)")
+}
+
+// addInputFlags registers the flags selecting the files to process
+func addInputFlags(flags *pflag.FlagSet) {
+	addWalkFlags(flags)
+	flags.BoolVarP(&fromStdin, "from-stdin", "f", false, "read file names to process from stdin, one per line")
+	flags.BoolVarP(&nullInput, "null-input", "0", false, "file names read from stdin are NUL-separated (auto-detected otherwise)")
+}
+
+// addHashFlags registers the flags of hash mode
+func addHashFlags(flags *pflag.FlagSet) {
+	flags.StringVarP(&dedupMode, "deduplicate", "d", "", "print only the first path of each unique hash (exact, or fuzzy to also drop near-duplicates)")
+	flags.Lookup("deduplicate").NoOptDefVal = dedupExact
+	flags.BoolVar(&hashOnly, "hash-only", false, "print only the hash of each file, without its path")
+	flags.BoolVar(&withMeta, "with-meta", false, "append the file size and modification time (RFC 3339, UTC) to each record")
+}
+
+// addMatchFlags registers the flags of match mode, with the hash files given by the flag name
+func addMatchFlags(flags *pflag.FlagSet, name string) {
+	flags.StringSliceVarP(&matchFiles, name, "m", nil, "match files against hashes in file (repeat or comma-separate for several files)")
+	flags.BoolVar(&warnCase, "warn-case", false, "warn about known hashes that look case-folded by the system they were stored in")
+	flags.BoolVar(&firstMatch, "first-match", false, "print only the first known hash each file scores at least --min-score against")
+}
+
+func init() {
+	addInputFlags(hashCmd.Flags())
+	addHashFlags(hashCmd.Flags())
+	hashCmd.Flags().IntVar(&minScore, "min-score", 90, "with --deduplicate=fuzzy, drop files scoring at least this against a printed one")
+	rootCmd.AddCommand(hashCmd)
+
+	addInputFlags(matchCmd.Flags())
+	addMatchFlags(matchCmd.Flags(), "database")
+	matchCmd.Flags().IntVar(&minScore, "min-score", 90, "with --first-match, the score that ends matching")
+	matchCmd.MarkFlagRequired("database")
+	rootCmd.AddCommand(matchCmd)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// verifyThreshold is the lowest score at which a file counts as unchanged
+var verifyThreshold int
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify [options] baseline",
+	Short: "re-hash the files listed in a baseline and flag the ones that changed",
+	Long: "verify reads a baseline hash file, as written by ssdeep, re-hashes each listed path and compares\n" +
+		"the new hash with the recorded one. Files scoring below --threshold are flagged as changed.",
+	Args:                  cobra.ExactArgs(1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runVerify(args[0]); err != nil {
+			if !silent {
+				fmt.Fprintf(stderr, "ssdeep: %v\n", err)
+			}
+			os.Exit(1)
+		}
+	},
+}
+
+// runVerify checks every file of the baseline, printing "path: score ok" for unchanged
+// files and "path: score changed" for files that drifted below verifyThreshold
+func runVerify(baseline string) error {
+	file, err := os.Open(baseline)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		hash, path, ok := parseRecord(scanner.Text())
+		if !ok {
+			continue
+		}
+		verifyFile(path, hash)
+	}
+	return scanner.Err()
+}
+
+// verifyFile re-hashes path and reports how it compares with its baseline hash
+func verifyFile(path, baseline string) {
+	hash, err := hashFile(path)
+	if err != nil {
+		reportError(path, err)
+		return
+	}
+	succeeded = true
+
+	score, err := compareHashes(path, hash, baseline)
+	if err != nil {
+		reportError(path, fmt.Errorf("baseline hash: %w", err))
+		return
+	}
+
+	status := "ok"
+	if score < verifyThreshold {
+		status = "changed"
+		drifted = true
+	}
+	fmt.Fprintf(stdout, "%s: %d %s\n", displayPath(path), score, status)
+}
+
+func init() {
+	verifyCmd.Flags().IntVarP(&verifyThreshold, "threshold", "t", 90, "flag files scoring below this against their baseline hash")
+	rootCmd.AddCommand(verifyCmd)
+}
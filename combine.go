@@ -0,0 +1,85 @@
+package ssdeep
+
+// Combine approximates the hash of the concatenation of two inputs from their hashes
+// hash1 and hash2, for when only the hashes of the parts are at hand.
+//
+// The result is an estimate, not the hash Bytes would return for the concatenated data:
+//   - The character of the chunk spanning the boundary between the inputs is unknown,
+//     so the trailing character of hash1, computed for an incomplete chunk, is dropped.
+//   - Both hashes are brought to the larger of their block sizes, which needs them to be
+//     at most 2x apart; otherwise ErrIncompatibleBlockSizes is returned. For hashes 2x
+//     apart, only the input with the larger block size has a digest at twice that size,
+//     so the second segment of the result covers that input alone.
+//   - When the joined digest is too long for its block size, the block size is doubled,
+//     as Bytes would for the longer input. The digest at four times the original block
+//     size is unknown, so the second segment of the result is then empty.
+//   - First segments longer than 64 characters keep their first 64 and second segments
+//     longer than 32 their first 32, the lengths of the digests of long inputs. Unlike
+//     those, whose last character covers all their remaining data, the last character
+//     kept is that of a single chunk.
+//
+// A hash of empty or all-zero input, which has empty segments, combines into the other hash.
+// Hashes computed with different seeds yield ErrSeedMismatch, hashes encoded with different
+// base64 alphabets yield ErrIncompatibleCharsets.
+func Combine(hash1, hash2 string) (string, error) {
+	h1, err := Parse(hash1)
+	if err != nil {
+		return "", err
+	}
+	h2, err := Parse(hash2)
+	if err != nil {
+		return "", err
+	}
+	if err := h1.comparable(h2); err != nil {
+		return "", err
+	}
+
+	if h1.Part1 == "" && h1.Part2 == "" {
+		return h2.String(), nil
+	}
+	if h2.Part1 == "" && h2.Part2 == "" {
+		return h1.String(), nil
+	}
+
+	// Digests of both inputs at a common block size, and at twice that size if known
+	var a1, a2, b1, b2 string
+	blockSize := max(h1.BlockSize, h2.BlockSize)
+	switch {
+	case h1.BlockSize == h2.BlockSize:
+		a1, a2, b1, b2 = h1.Part1, h1.Part2, h2.Part1, h2.Part2
+	case h1.BlockSize*2 == h2.BlockSize:
+		a1, b1, b2 = h1.Part2, h2.Part1, h2.Part2
+	case h2.BlockSize*2 == h1.BlockSize:
+		a1, a2, b1 = h1.Part1, h1.Part2, h2.Part2
+	default:
+		return "", ErrIncompatibleBlockSizes
+	}
+
+	part1, part2 := joinDigests(a1, b1), joinDigests(a2, b2)
+	if len(part1) > spamSumLength && part2 != "" {
+		blockSize *= 2
+		part1, part2 = part2, ""
+	}
+
+	combined := HashInfo{
+		BlockSize: blockSize,
+		Part1:     truncateDigest(part1, spamSumLength),
+		Part2:     truncateDigest(part2, spamSumLength/2),
+		Seed:      h1.Seed,
+	}
+	return combined.String(), nil
+}
+
+// joinDigests appends digest b to digest a, without the trailing character of a
+// that b supersedes
+func joinDigests(a, b string) string {
+	if a != "" && b != "" {
+		a = a[:len(a)-1]
+	}
+	return a + b
+}
+
+// truncateDigest shortens a digest to its first n characters, the length of a full segment
+func truncateDigest(digest string, n int) string {
+	return digest[:min(len(digest), n)]
+}
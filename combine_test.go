@@ -0,0 +1,81 @@
+package ssdeep
+
+import (
+	"math/rand/v2"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCombine(t *testing.T) {
+	rng := rand.New(rand.NewPCG(3, 4))
+	random := func(n int) []byte {
+		data := make([]byte, n)
+		for i := range data {
+			data[i] = byte(rng.Uint32())
+		}
+		return data
+	}
+
+	for _, sizes := range [][2]int{
+		{7000, 7000},   // Same block size, doubled for the concatenation
+		{3000, 2500},   // Same block size, doubled for the concatenation
+		{20000, 20000}, // Same block size, doubled for the concatenation
+		{12000, 5000},  // Block sizes 2x apart
+		{50000, 60000}, // Same block size, doubled for the concatenation
+	} {
+		a, b := random(sizes[0]), random(sizes[1])
+		hashA, err := Bytes(a)
+		require.NoError(t, err)
+		hashB, err := Bytes(b)
+		require.NoError(t, err)
+		want, err := Bytes(append(a, b...))
+		require.NoError(t, err)
+
+		combined, err := Combine(hashA, hashB)
+		require.NoError(t, err)
+		score, err := Compare(combined, want)
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, score, 80, "%v: %s vs %s", sizes, combined, want)
+
+		// The approximation is closer to the concatenation than either part
+		scoreA, err := Compare(hashA, want)
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, score, scoreA, "%v", sizes)
+	}
+}
+
+func TestCombineEdgeCases(t *testing.T) {
+	const quick = "3:FJKKIUKact:FHIGi"
+
+	combined, err := Combine("3::", quick)
+	require.NoError(t, err)
+	require.Equal(t, quick, combined)
+	combined, err = Combine(quick, "3::")
+	require.NoError(t, err)
+	require.Equal(t, quick, combined)
+
+	combined, err = Combine(quick, quick)
+	require.NoError(t, err)
+	require.Equal(t, "3:FJKKIUKacFJKKIUKact:FHIGFHIGi", combined)
+
+	// First segments keep their first 64 characters and second ones their first 32,
+	// the lengths of full segments
+	long := "3:ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/:"
+	combined, err = Combine(long, long)
+	require.NoError(t, err)
+	require.Equal(t, "3:ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+A:", combined)
+	long = "3:A:ABCDEFGHIJKLMNOPQRSTUVWXYZabcdef"
+	combined, err = Combine(long, long)
+	require.NoError(t, err)
+	require.Equal(t, "3:A:ABCDEFGHIJKLMNOPQRSTUVWXYZabcdeA", combined)
+
+	_, err = Combine("3:FJKKIUKact:FHIGi", "48:FJKKIUKact:FHIGi")
+	require.ErrorIs(t, err, ErrIncompatibleBlockSizes)
+	_, err = Combine(quick, quick+":1234")
+	require.ErrorIs(t, err, ErrSeedMismatch)
+	_, err = Combine("3:ab+c:d", "3:ab-c:d")
+	require.ErrorIs(t, err, ErrIncompatibleCharsets)
+	_, err = Combine("invalid", quick)
+	require.ErrorIs(t, err, ErrInvalidHash)
+}
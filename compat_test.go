@@ -0,0 +1,93 @@
+package ssdeep
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestCompatibilityWithOfficialBinary hashes generated files with both File and the official
+// ssdeep binary, when it is on PATH, and requires identical hashes. It also checks the hashes
+// pinned in tinyInputs. testdata/docker-compose.yml runs it in a container with the official
+// binary installed.
+//
+// File is called with WithAdaptiveBlockSize, since the official tool retries at half the
+// block size when the first segment is short; by default the estimated block size is kept,
+// so repetitive inputs hash differently without the option.
+func TestCompatibilityWithOfficialBinary(t *testing.T) {
+	bin, err := exec.LookPath("ssdeep")
+	if err != nil {
+		t.Skip("official ssdeep binary not found on PATH")
+	}
+
+	dir := t.TempDir()
+	rng := rand.New(rand.NewPCG(0x55de, 0xe9))
+	for i := range 20 {
+		// Sizes spread evenly on a log scale from 100 B to 10 MB
+		size := int(100 * math.Pow(1e5, float64(i)/19))
+		data := make([]byte, size)
+		switch i % 3 {
+		case 0:
+			for j := range data {
+				data[j] = byte(rng.Uint32())
+			}
+		case 1:
+			// Text-like data with repeats, which hashes very differently from noise
+			words := []string{"fuzzy ", "hash ", "piecewise ", "rolling ", "context ", "trigger ", "\n"}
+			var buf bytes.Buffer
+			for buf.Len() < size {
+				buf.WriteString(words[rng.IntN(len(words))])
+			}
+			data = buf.Bytes()[:size]
+		default:
+			// A repeated line hits few boundaries, so the block size is halved
+			data = bytes.Repeat([]byte("the same line, over and over\n"), size/29+1)[:size]
+		}
+
+		path := filepath.Join(dir, fmt.Sprintf("file%02d.bin", i))
+		require.NoError(t, os.WriteFile(path, data, 0o600))
+
+		want := officialHash(t, bin, path)
+		got, err := File(path, WithAdaptiveBlockSize())
+		require.NoError(t, err)
+		require.Equal(t, want, got, "%d bytes", size)
+	}
+
+	for i, tc := range tinyInputs {
+		path := filepath.Join(dir, fmt.Sprintf("tiny%02d.bin", i))
+		require.NoError(t, os.WriteFile(path, tc.data, 0o600))
+		require.Equal(t, officialHash(t, bin, path), tc.hash, tc.name)
+	}
+}
+
+// officialHash runs the official ssdeep binary on path and returns the hash it printed.
+// With -b the output is a header line followed by `hash,"basename"`.
+func officialHash(t *testing.T, bin, path string) string {
+	t.Helper()
+
+	out, err := exec.Command(bin, "-b", path).Output()
+	require.NoError(t, err)
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "ssdeep,") {
+			continue
+		}
+		hash, name, ok := strings.Cut(line, ",")
+		if ok && strings.Trim(name, `"`) == filepath.Base(path) {
+			return hash
+		}
+	}
+	t.Fatalf("no hash for %s in ssdeep output:\n%s", path, out)
+	return ""
+}
@@ -0,0 +1,106 @@
+package ssdeep
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+)
+
+// Stages of HashDirectory at which a HashError can occur
+const (
+	StageWalk = "walk" // listing a directory or stat'ing an entry failed
+	StageOpen = "open" // opening a file failed
+	StageHash = "hash" // reading or hashing an opened file failed
+)
+
+// HashError describes a failure to hash one path in HashDirectory.
+type HashError struct {
+	Path  string // Path that failed
+	Stage string // StageWalk, StageOpen or StageHash
+	Cause error  // Underlying error
+}
+
+func (e *HashError) Error() string {
+	return fmt.Sprintf("ssdeep: %s %s: %v", e.Stage, e.Path, e.Cause)
+}
+
+func (e *HashError) Unwrap() error {
+	return e.Cause
+}
+
+// FileResult is the outcome of hashing one file in HashDirectory.
+// Exactly one of Hash and Err is set; Err is always a *HashError.
+type FileResult struct {
+	Path string
+	Hash string
+	Err  error
+}
+
+// HashDirectory walks the tree rooted at root in lexical order and hashes every
+// non-directory entry, sending one FileResult per file, or per path that could not be
+// walked, on the returned channel. The channel is closed when the walk is done or ctx
+// is cancelled; the caller must drain it or cancel ctx to release the walking goroutine.
+// An unreadable directory is reported once and its subtree skipped.
+func HashDirectory(ctx context.Context, root string, options ...Option) <-chan FileResult {
+	open := func(path string) (io.ReadCloser, error) {
+		file, err := openFile(path, options)
+		if err != nil {
+			return nil, err
+		}
+		return file, nil
+	}
+	return hashDirectory(ctx, root, open, options)
+}
+
+// hashDirectory implements HashDirectory, opening files with open
+func hashDirectory(ctx context.Context, root string, open func(string) (io.ReadCloser, error), options []Option) <-chan FileResult {
+	results := make(chan FileResult)
+
+	send := func(res FileResult) error {
+		select {
+		case results <- res:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	go func() {
+		defer close(results)
+
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if sendErr := send(FileResult{Path: path, Err: &HashError{Path: path, Stage: StageWalk, Cause: err}}); sendErr != nil {
+					return sendErr
+				}
+				if d != nil && d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() {
+				return nil
+			}
+			return send(hashDirectoryFile(ctx, path, open, options))
+		})
+	}()
+
+	return results
+}
+
+// hashDirectoryFile hashes the file at path for hashDirectory
+func hashDirectoryFile(ctx context.Context, path string, open func(string) (io.ReadCloser, error), options []Option) FileResult {
+	file, err := open(path)
+	if err != nil {
+		return FileResult{Path: path, Err: &HashError{Path: path, Stage: StageOpen, Cause: err}}
+	}
+	defer file.Close()
+
+	hash, err := StreamContext(ctx, file, options...)
+	if err != nil {
+		return FileResult{Path: path, Err: &HashError{Path: path, Stage: StageHash, Cause: err}}
+	}
+	return FileResult{Path: path, Hash: hash}
+}
@@ -0,0 +1,120 @@
+package ssdeep
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// collect drains a HashDirectory channel into a map from path to result
+func collect(results <-chan FileResult) map[string]FileResult {
+	m := make(map[string]FileResult)
+	for res := range results {
+		m[res.Path] = res
+	}
+	return m
+}
+
+// failingReader returns err from every Read
+type failingReader struct {
+	err error
+}
+
+func (r failingReader) Read([]byte) (int, error) { return 0, r.err }
+func (r failingReader) Close() error             { return nil }
+
+func TestHashDirectory(t *testing.T) {
+	dir := t.TempDir()
+	data := []byte("The quick brown fox jumps over the lazy dog")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0o700))
+	for _, name := range []string{"a.txt", "sub/b.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0o600))
+	}
+
+	results := collect(HashDirectory(context.Background(), dir))
+	require.Len(t, results, 2)
+	for _, name := range []string{"a.txt", "sub/b.txt"} {
+		res := results[filepath.Join(dir, name)]
+		require.NoError(t, res.Err)
+		require.Equal(t, "3:FJKKIUKact:FHIGi", res.Hash)
+	}
+}
+
+func TestHashDirectoryErrorStages(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"noopen", "badread", "ok"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(strings.Repeat(name, 20)), 0o600))
+	}
+
+	errRead := errors.New("read failed")
+	open := func(path string) (io.ReadCloser, error) {
+		switch filepath.Base(path) {
+		case "noopen":
+			return nil, fs.ErrPermission
+		case "badread":
+			return failingReader{errRead}, nil
+		}
+		return os.Open(path)
+	}
+
+	results := collect(hashDirectory(context.Background(), dir, open, nil))
+	require.Len(t, results, 3)
+	require.NoError(t, results[filepath.Join(dir, "ok")].Err)
+
+	var hashErr *HashError
+	require.ErrorAs(t, results[filepath.Join(dir, "noopen")].Err, &hashErr)
+	require.Equal(t, StageOpen, hashErr.Stage)
+	require.ErrorIs(t, hashErr, fs.ErrPermission)
+
+	require.ErrorAs(t, results[filepath.Join(dir, "badread")].Err, &hashErr)
+	require.Equal(t, StageHash, hashErr.Stage)
+	require.ErrorIs(t, hashErr, errRead)
+
+	missing := filepath.Join(dir, "missing")
+	results = collect(HashDirectory(context.Background(), missing))
+	require.ErrorAs(t, results[missing].Err, &hashErr)
+	require.Equal(t, StageWalk, hashErr.Stage)
+	require.ErrorIs(t, hashErr, fs.ErrNotExist)
+}
+
+func TestHashDirectoryUnreadable(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can read directories regardless of permissions")
+	}
+
+	dir := t.TempDir()
+	locked := filepath.Join(dir, "locked")
+	require.NoError(t, os.Mkdir(locked, 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(locked, "file"), []byte("data"), 0o600))
+	require.NoError(t, os.Chmod(locked, 0))
+	t.Cleanup(func() { os.Chmod(locked, 0o700) })
+
+	results := collect(HashDirectory(context.Background(), dir))
+	var hashErr *HashError
+	require.ErrorAs(t, results[locked].Err, &hashErr)
+	require.Equal(t, StageWalk, hashErr.Stage)
+	require.ErrorIs(t, hashErr, fs.ErrPermission)
+}
+
+func TestHashDirectoryCancel(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b", "c"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("data"), 0o600))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results := HashDirectory(ctx, dir)
+	<-results
+	cancel()
+
+	// The walk stops and closes the channel instead of blocking forever
+	for range results {
+	}
+}
@@ -0,0 +1,111 @@
+package ssdeep
+
+import (
+	"strconv"
+	"strings"
+)
+
+// maxBlockSizeExponent is the largest exponent such that minBlockSize << exponent fits in uint32
+const maxBlockSizeExponent = 30
+
+// Encode packs an ssdeep hash string into a compact binary form for fixed-width storage.
+// The layout is:
+//   - 1 byte: block size exponent, where blockSize = 3 << exponent
+//   - 1 byte: length of part1
+//   - 1 byte: length of part2
+//   - both segments as 6-bit base64 indexes, packed big-endian and zero padded to a byte boundary
+//
+// The encoded form round-trips exactly through DecodeString; seeded hashes are not supported.
+// It is a storage format only: Compare and the other comparison functions still
+// operate on the string form.
+func Encode(hash string) ([]byte, error) {
+	h, err := Parse(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	exp, ok := blockSizeExponent(h.BlockSize)
+	if !ok || h.Seed != 0 || len(h.Part1) > 0xff || len(h.Part2) > 0xff {
+		return nil, ErrInvalidHash
+	}
+
+	n := len(h.Part1) + len(h.Part2)
+	buf := make([]byte, 3, 3+(n*6+7)/8)
+	buf[0] = exp
+	buf[1] = byte(len(h.Part1))
+	buf[2] = byte(len(h.Part2))
+
+	var (
+		acc  uint32
+		bits uint
+	)
+	for _, part := range [...]string{h.Part1, h.Part2} {
+		for i := range len(part) {
+			v := strings.IndexByte(base64Chars, part[i])
+			if v < 0 {
+				return nil, ErrInvalidHash
+			}
+
+			acc = acc<<6 | uint32(v)
+			bits += 6
+			for bits >= 8 {
+				bits -= 8
+				buf = append(buf, byte(acc>>bits))
+			}
+		}
+	}
+	if bits > 0 {
+		buf = append(buf, byte(acc<<(8-bits)))
+	}
+
+	return buf, nil
+}
+
+// DecodeString unpacks a hash produced by Encode back into its "blockSize:hash1:hash2" string form.
+func DecodeString(data []byte) (string, error) {
+	if len(data) < 3 || data[0] > maxBlockSizeExponent {
+		return "", ErrInvalidEncoding
+	}
+
+	n1, n2 := int(data[1]), int(data[2])
+	packed := data[3:]
+	if len(packed) != ((n1+n2)*6+7)/8 {
+		return "", ErrInvalidEncoding
+	}
+
+	hash := make([]byte, 0, n1+n2+12)
+	hash = strconv.AppendUint(hash, uint64(minBlockSize)<<data[0], 10)
+	hash = append(hash, ':')
+
+	var (
+		acc  uint32
+		bits uint
+	)
+	for i := range n1 + n2 {
+		if i == n1 {
+			hash = append(hash, ':')
+		}
+		for bits < 6 {
+			acc = acc<<8 | uint32(packed[0])
+			packed = packed[1:]
+			bits += 8
+		}
+		bits -= 6
+		hash = append(hash, base64Chars[(acc>>bits)&0x3f])
+	}
+	if n2 == 0 {
+		hash = append(hash, ':')
+	}
+
+	return string(hash), nil
+}
+
+// blockSizeExponent returns k such that blockSize == minBlockSize << k
+func blockSizeExponent(blockSize uint32) (byte, bool) {
+	for k := byte(0); k <= maxBlockSizeExponent; k++ {
+		if uint32(minBlockSize)<<k == blockSize {
+			return k, true
+		}
+	}
+	return 0, false
+}
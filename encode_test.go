@@ -0,0 +1,40 @@
+package ssdeep
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeRoundTrip(t *testing.T) {
+	hashes := []string{
+		"3:FJKKIUKact:FHIGi",
+		"3:M3+4CDTfWRcyNEqrBFWMEWM8XJ:M3KDKKqzZEL8XJ",
+		"196608:m3SuutoWSz3nONRfeuYzllWVa7KqNoweSDLft2SOQp1fy/x7ri:mbuQznoRfepzllWABp1fy/g",
+		"3::",
+		"6:abc:",
+		"12::xyz",
+	}
+
+	for _, h := range hashes {
+		encoded, err := Encode(h)
+		require.NoError(t, err, "Encode failed for %s", h)
+		require.Less(t, len(encoded), len(h)+3)
+
+		decoded, err := DecodeString(encoded)
+		require.NoError(t, err, "Decode failed for %s", h)
+		require.Equal(t, h, decoded)
+	}
+}
+
+func TestEncodeInvalid(t *testing.T) {
+	for _, h := range []string{"5:abc:def", "3:a-b:c", "3:abc"} {
+		_, err := Encode(h)
+		require.Error(t, err, "Encode should fail for %s", h)
+	}
+
+	for _, data := range [][]byte{nil, {0, 1}, {31, 0, 0}, {0, 2, 0, 0xff}} {
+		_, err := DecodeString(data)
+		require.ErrorIs(t, err, ErrInvalidEncoding)
+	}
+}
@@ -0,0 +1,21 @@
+package ssdeep
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// openNoAtime opens path with O_NOATIME, falling back to a regular open when
+// the kernel refuses the flag because the process does not own the file
+func openNoAtime(path string) (*os.File, error) {
+	fd, err := syscall.Open(path, syscall.O_RDONLY|syscall.O_CLOEXEC|syscall.O_NOATIME, 0)
+	if errors.Is(err, syscall.EPERM) {
+		return os.Open(path)
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+
+	return os.NewFile(uintptr(fd), path), nil
+}
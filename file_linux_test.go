@@ -0,0 +1,61 @@
+package ssdeep
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileWithNoAtime(t *testing.T) {
+	data, err := os.ReadFile("testdata/sample2.txt")
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "sample")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+
+	// Push atime far enough into the past that a relatime mount would update it
+	past := time.Now().Add(-72 * time.Hour)
+	require.NoError(t, os.Chtimes(path, past, past))
+	before := atime(t, path)
+
+	hash, err := File(path, WithNoAtime())
+	require.NoError(t, err)
+	require.Equal(t, "3:M3+4CDTfWRcyNEqrBFWMEWM8XJ:M3KDKKqzZEL8XJ", hash)
+	require.Equal(t, before, atime(t, path))
+}
+
+func atime(t *testing.T, path string) syscall.Timespec {
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	return info.Sys().(*syscall.Stat_t).Atim
+}
+
+func TestFileNamedPipe(t *testing.T) {
+	data := make([]byte, 64*1024)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	path := filepath.Join(t.TempDir(), "fifo")
+	require.NoError(t, syscall.Mkfifo(path, 0o600))
+
+	go func() {
+		w, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			return
+		}
+		defer w.Close()
+		w.Write(data)
+	}()
+
+	hash, err := File(path)
+	require.NoError(t, err)
+
+	expectedHash, err := Bytes(data)
+	require.NoError(t, err)
+	require.Equal(t, expectedHash, hash)
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package ssdeep
+
+import "os"
+
+// openNoAtime opens path for reading; O_NOATIME is only available on Linux
+func openNoAtime(path string) (*os.File, error) {
+	return os.Open(path)
+}
@@ -0,0 +1,119 @@
+package ssdeep
+
+import (
+	"errors"
+	"io"
+)
+
+// readFromBufferSize is the size of the buffer ReadFrom reads through
+const readFromBufferSize = 32 * 1024
+
+// Hasher computes ssdeep fuzzy hashes with a state it owns, for reuse across many
+// inputs without going through the shared state pool. The block size is fixed by
+// Reset from a size hint, so the hint should be the exact input length to match Bytes.
+// A Hasher is an io.WriteCloser, so it can take part in pipelines such as
+// io.MultiWriter(dest, h): the input is written, Close finalizes the hash and Sum
+// returns it. A Hasher is not safe for concurrent use.
+//
+//	h := ssdeep.NewHasher()
+//	for _, data := range inputs {
+//		h.Reset(int64(len(data)))
+//		h.Write(data)
+//		h.Close()
+//		hash, err := h.Sum()
+//	}
+type Hasher struct {
+	state  ssdeepState
+	buf    []byte // Read buffer of ReadFrom, allocated on first use and kept across Resets
+	closed bool   // Whether Close was called since the last Reset
+}
+
+// NewHasher returns a Hasher using the minimum block size until Reset is called.
+func NewHasher() *Hasher {
+	h := &Hasher{
+		state: ssdeepState{
+			hash1: make([]byte, 0, hashBufferCap),
+			hash2: make([]byte, 0, hashBufferCap),
+		},
+	}
+	h.state.reset(minBlockSize)
+	return h
+}
+
+// Reset discards any written data and selects the block size for an input of sizeHint bytes.
+// It also reopens a closed Hasher.
+func (h *Hasher) Reset(sizeHint int64) {
+	h.state.reset(estimateBlockSize(sizeHint))
+	h.closed = false
+}
+
+// Write adds p to the data being hashed. It fails with ErrHasherClosed after Close,
+// and never fails otherwise.
+func (h *Hasher) Write(p []byte) (int, error) {
+	if h.closed {
+		return 0, ErrHasherClosed
+	}
+	return h.state.Write(p)
+}
+
+// Close marks the end of the input and finalizes the hash returned by Sum. Later
+// writes fail with ErrHasherClosed rather than silently changing the hash. Closing
+// a closed Hasher returns ErrHasherClosed; Reset makes it usable again.
+func (h *Hasher) Close() error {
+	if h.closed {
+		return ErrHasherClosed
+	}
+	h.closed = true
+	return nil
+}
+
+// ReadFrom implements io.ReaderFrom, writing the data read from r until EOF through a
+// buffer the Hasher reuses across calls. It returns the number of bytes read and any
+// error other than io.EOF. Like Write, it keeps the block size selected by Reset,
+// so io.Copy(h, r) and h.ReadFrom(r) hash the same as Bytes when the hint is exact,
+// and fails with ErrHasherClosed after Close.
+func (h *Hasher) ReadFrom(r io.Reader) (int64, error) {
+	if h.closed {
+		return 0, ErrHasherClosed
+	}
+	if h.buf == nil {
+		h.buf = make([]byte, readFromBufferSize)
+	}
+
+	var total int64
+	for {
+		n, err := r.Read(h.buf)
+		if n > 0 {
+			h.state.Write(h.buf[:n])
+			total += int64(n)
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return total, nil
+			}
+			return total, err
+		}
+	}
+}
+
+// Sum returns the fuzzy hash of the data written since the last Reset. It fails with
+// ErrHashNotFinalized until Close is called, as the input may not be complete.
+func (h *Hasher) Sum() (string, error) {
+	if !h.closed {
+		return "", ErrHashNotFinalized
+	}
+	return h.state.Sum(), nil
+}
+
+// BytesWritten returns the number of bytes written since the last Reset.
+func (h *Hasher) BytesWritten() int64 {
+	return h.state.bytesWritten()
+}
+
+// Len returns the number of characters accumulated so far in the two segments of the
+// hash, without the trailing characters Sum adds. It does not finalize or modify the
+// hash, so it can be used to monitor an input in progress: segments still empty half
+// way through a large input suggest a block size that will not yield a useful hash.
+func (h *Hasher) Len() (part1, part2 int) {
+	return len(h.state.hash1), len(h.state.hash2)
+}
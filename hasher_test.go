@@ -0,0 +1,172 @@
+package ssdeep
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHasherMatchesBytes(t *testing.T) {
+	h := NewHasher()
+	for i := range 20 {
+		data := []byte(strings.Repeat(fmt.Sprintf("line %d of the quick brown fox\n", i), i*50+1))
+		expected, err := Bytes(data)
+		require.NoError(t, err)
+
+		h.Reset(int64(len(data)))
+		n, err := h.Write(data)
+		require.NoError(t, err)
+		require.Equal(t, len(data), n)
+		require.Equal(t, int64(len(data)), h.BytesWritten())
+		require.NoError(t, h.Close())
+		requireSum(t, h, expected, "input %d", i)
+	}
+}
+
+func TestHasherChunkedWrites(t *testing.T) {
+	data := []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 200))
+	expected, err := Bytes(data)
+	require.NoError(t, err)
+
+	h := NewHasher()
+	h.Reset(int64(len(data)))
+	for chunk := range slices.Chunk(data, 7) {
+		h.Write(chunk)
+	}
+	require.NoError(t, h.Close())
+	requireSum(t, h, expected)
+	// Sum leaves the state untouched
+	requireSum(t, h, expected)
+}
+
+func TestHasherReadFrom(t *testing.T) {
+	h := NewHasher()
+	for _, size := range []int{1, 100, readFromBufferSize, 3*readFromBufferSize + 17, 1 << 20} {
+		data := []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", size/45+1))[:size]
+		expected, err := Bytes(data)
+		require.NoError(t, err)
+
+		// A reader yielding a byte at a time exercises short reads
+		for _, r := range []io.Reader{bytes.NewBuffer(data), iotest.OneByteReader(bytes.NewReader(data))} {
+			h.Reset(int64(len(data)))
+			n, err := h.ReadFrom(r)
+			require.NoError(t, err)
+			require.Equal(t, int64(len(data)), n)
+			require.NoError(t, h.Close())
+			requireSum(t, h, expected, "size %d", size)
+		}
+
+		// io.Copy goes through ReadFrom for readers without WriteTo
+		h.Reset(int64(len(data)))
+		_, err = io.Copy(h, struct{ io.Reader }{bytes.NewReader(data)})
+		require.NoError(t, err)
+		require.NoError(t, h.Close())
+		requireSum(t, h, expected, "size %d, io.Copy", size)
+	}
+
+	// Data read before an error is hashed and counted
+	failure := errors.New("read failed")
+	h.Reset(10)
+	n, err := h.ReadFrom(io.MultiReader(strings.NewReader("0123456789"), iotest.ErrReader(failure)))
+	require.ErrorIs(t, err, failure)
+	require.Equal(t, int64(10), n)
+	require.Equal(t, int64(10), h.BytesWritten())
+}
+
+func TestHasherClose(t *testing.T) {
+	data := []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 200))
+	expected, err := Bytes(data)
+	require.NoError(t, err)
+
+	h := NewHasher()
+	h.Reset(int64(len(data)))
+	var dest bytes.Buffer
+	var w io.WriteCloser = h
+	_, err = io.Copy(io.MultiWriter(&dest, w), bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, data, dest.Bytes())
+
+	// The hash is only available once finalized by Close
+	_, err = h.Sum()
+	require.ErrorIs(t, err, ErrHashNotFinalized)
+	require.NoError(t, w.Close())
+	requireSum(t, h, expected)
+
+	// The hash is final: more data is refused and closing again fails
+	n, err := h.Write([]byte("more"))
+	require.ErrorIs(t, err, ErrHasherClosed)
+	require.Zero(t, n)
+	_, err = h.ReadFrom(strings.NewReader("more"))
+	require.ErrorIs(t, err, ErrHasherClosed)
+	require.ErrorIs(t, h.Close(), ErrHasherClosed)
+	requireSum(t, h, expected)
+
+	// Reset reopens the Hasher, whose hash is again not final until Close
+	h.Reset(int64(len(data)))
+	_, err = h.Write(data)
+	require.NoError(t, err)
+	_, err = h.Sum()
+	require.ErrorIs(t, err, ErrHashNotFinalized)
+	require.NoError(t, h.Close())
+	requireSum(t, h, expected)
+}
+
+// requireSum checks that h is finalized with the hash expected
+func TestHasherLen(t *testing.T) {
+	data := make([]byte, 1<<20)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+
+	h := NewHasher()
+	h.Reset(int64(len(data)))
+
+	l1, l2 := h.Len()
+	require.Zero(t, l1)
+	require.Zero(t, l2)
+
+	quarter := len(data) / 4
+	for i := range 4 {
+		_, err = h.Write(data[i*quarter : (i+1)*quarter])
+		require.NoError(t, err)
+
+		n1, n2 := h.Len()
+		require.GreaterOrEqual(t, n1, l1)
+		require.GreaterOrEqual(t, n2, l2)
+		l1, l2 = n1, n2
+	}
+	require.NotZero(t, l1)
+
+	// Len must not modify the hash
+	require.NoError(t, h.Close())
+	sum, err := h.Sum()
+	require.NoError(t, err)
+	h.Len()
+	requireSum(t, h, sum)
+}
+
+func requireSum(t *testing.T, h *Hasher, expected string, msgAndArgs ...any) {
+	t.Helper()
+	hash, err := h.Sum()
+	require.NoError(t, err, msgAndArgs...)
+	require.Equal(t, expected, hash, msgAndArgs...)
+}
+
+func BenchmarkHasherSmall(b *testing.B) {
+	data := []byte("The quick brown fox jumps over the lazy dog")
+	h := NewHasher()
+	b.ReportAllocs()
+	for b.Loop() {
+		h.Reset(int64(len(data)))
+		h.Write(data)
+		h.Close()
+		h.Sum()
+	}
+}
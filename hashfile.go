@@ -0,0 +1,67 @@
+package ssdeep
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// hashFileHeader is the first line of hash files written by the official ssdeep tool
+const hashFileHeader = "ssdeep,1.1--blocksize:hash:hash,filename"
+
+// WriteHashFile writes entries to path in the format of the official ssdeep tool, which
+// the ssdeep command reads with -m: a header line, then one `hash,"filename"` line per
+// record, the file name being the "path" key of its Metadata.
+//
+// The file is written to path + ".tmp", synced to disk and renamed over path, so that
+// a crash mid-write leaves either the previous file or the complete new one, never a
+// truncated file that would read as a valid but incomplete list. On Windows the rename
+// replaces an existing file with MoveFileEx, as os.Rename is not atomic there.
+//
+// File names are written unescaped, as the official tool does. Readers take the last
+// quote of a line as the end of the name, so names may contain quotes and commas, but
+// a name with a line break would split its line: WriteHashFile then fails with
+// ErrInvalidFileName before writing anything.
+func WriteHashFile(path string, entries []Record) error {
+	for _, e := range entries {
+		if name := e.Metadata["path"]; strings.ContainsAny(name, "\r\n") {
+			return fmt.Errorf("%w: %q", ErrInvalidFileName, name)
+		}
+	}
+
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if err := writeHashFile(file, entries); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := replaceFile(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// writeHashFile writes and syncs the contents of a hash file
+func writeHashFile(file *os.File, entries []Record) error {
+	w := bufio.NewWriter(file)
+	fmt.Fprintln(w, hashFileHeader)
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s,\"%s\"\n", e.Hash, e.Metadata["path"])
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return file.Sync()
+}
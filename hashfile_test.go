@@ -0,0 +1,57 @@
+package ssdeep
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteHashFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hashes.txt")
+
+	h1, err := Parse("3:FJKKIUKact:FHIGi")
+	require.NoError(t, err)
+	h2, err := Parse("3:FJKKIrKact:FHIrGi")
+	require.NoError(t, err)
+	entries := []Record{Record{Hash: h1}.WithMeta("path", "quick.txt"), Record{Hash: h2}.WithMeta("path", "dir/quick2.txt")}
+
+	require.NoError(t, WriteHashFile(path, entries))
+	const want = "ssdeep,1.1--blocksize:hash:hash,filename\n" +
+		"3:FJKKIUKact:FHIGi,\"quick.txt\"\n" +
+		"3:FJKKIrKact:FHIrGi,\"dir/quick2.txt\"\n"
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, want, string(data))
+
+	// Rewriting replaces the file and leaves no temporary file behind
+	require.NoError(t, WriteHashFile(path, entries[:1]))
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "ssdeep,1.1--blocksize:hash:hash,filename\n3:FJKKIUKact:FHIGi,\"quick.txt\"\n", string(data))
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	// A failed write keeps the previous file intact
+	require.NoError(t, os.Mkdir(path+".tmp", 0o700))
+	require.Error(t, WriteHashFile(path, entries))
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "ssdeep,1.1--blocksize:hash:hash,filename\n3:FJKKIUKact:FHIGi,\"quick.txt\"\n", string(data))
+	require.NoError(t, os.Remove(path+".tmp"))
+
+	// Names with line breaks are refused, leaving the previous file intact
+	for _, name := range []string{"two\nlines.txt", "carriage\rreturn.txt"} {
+		err := WriteHashFile(path, []Record{{Hash: h1, Metadata: map[string]string{"path": name}}})
+		require.ErrorIs(t, err, ErrInvalidFileName)
+		data, err = os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, "ssdeep,1.1--blocksize:hash:hash,filename\n3:FJKKIUKact:FHIGi,\"quick.txt\"\n", string(data))
+	}
+	files, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+}
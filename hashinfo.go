@@ -0,0 +1,279 @@
+package ssdeep
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"strconv"
+	"strings"
+)
+
+// HashInfo holds the components of an ssdeep hash in format "blockSize:part1:part2".
+//   - BlockSize: chunk size the hash was computed with
+//   - Part1: digest computed at BlockSize
+//   - Part2: digest computed at BlockSize * 2
+//   - Seed: custom initial piecewise hash value (see WithHashSeed), 0 for standard hashes
+//   - Charset: base64 alphabet detected in the segments
+type HashInfo struct {
+	BlockSize uint32
+	Part1     string
+	Part2     string
+	Seed      uint32
+	Charset   Charset
+}
+
+// Charset identifies the base64 alphabet used by hash segments.
+// Comparing hashes encoded with different alphabets is invalid, since the
+// same digest character is spelled differently in each.
+type Charset uint8
+
+const (
+	// CharsetUnknown means the segments contain none of the characters that
+	// tell the alphabets apart, so they are compatible with either
+	CharsetUnknown Charset = iota
+	// CharsetStandard is the "+/" alphabet used by the official ssdeep tool
+	CharsetStandard
+	// CharsetURLSafe is the "-_" alphabet used by URL-safe variants
+	CharsetURLSafe
+)
+
+// detectCharset reports the alphabet of the given segments, failing for a mix of both
+func detectCharset(part1, part2 string) (Charset, bool) {
+	standard := hasAnyByte(part1, part2, '+', '/')
+	urlSafe := hasAnyByte(part1, part2, '-', '_')
+
+	switch {
+	case standard && urlSafe:
+		return CharsetUnknown, false
+	case standard:
+		return CharsetStandard, true
+	case urlSafe:
+		return CharsetURLSafe, true
+	default:
+		return CharsetUnknown, true
+	}
+}
+
+// hasAnyByte reports whether part1 or part2 contains a or b.
+// strings.IndexByte is vectorized, which beats a per-character loop on hot comparison paths.
+func hasAnyByte(part1, part2 string, a, b byte) bool {
+	return strings.IndexByte(part1, a) >= 0 || strings.IndexByte(part1, b) >= 0 ||
+		strings.IndexByte(part2, a) >= 0 || strings.IndexByte(part2, b) >= 0
+}
+
+// Parse splits an ssdeep hash string into its block size and two segments.
+// The segments reference the input string, so parsing does not allocate.
+// Leading and trailing ASCII whitespace and a leading UTF-8 byte order mark, as left
+// by hash files edited on Windows, are ignored; ParseStrict rejects them.
+func Parse(hash string) (HashInfo, error) {
+	return parse(trimHash(hash))
+}
+
+// ParseStrict is Parse for hashes in canonical form only: it returns ErrInvalidHash
+// for hashes containing whitespace or a byte order mark.
+func ParseStrict(hash string) (HashInfo, error) {
+	if strings.ContainsAny(hash, hashSpace+byteOrderMark) {
+		return HashInfo{}, ErrInvalidHash
+	}
+	return parse(hash)
+}
+
+const (
+	hashSpace     = " \t\r\n"
+	byteOrderMark = "\ufeff"
+)
+
+// trimHash strips the whitespace and byte order mark Parse ignores around a hash
+func trimHash(hash string) string {
+	hash = strings.Trim(hash, hashSpace)
+	hash = strings.TrimPrefix(hash, byteOrderMark)
+	return strings.Trim(hash, hashSpace)
+}
+
+// parse implements ParseStrict once the hash has been checked
+func parse(hash string) (HashInfo, error) {
+	bs, rest, ok := strings.Cut(hash, ":")
+	if !ok {
+		return HashInfo{}, ErrInvalidHash
+	}
+
+	part1, part2, ok := strings.Cut(rest, ":")
+	if !ok {
+		return HashInfo{}, ErrInvalidHash
+	}
+
+	var seed uint64
+	if part2, rest, ok = strings.Cut(part2, ":"); ok {
+		var err error
+		if seed, err = strconv.ParseUint(rest, 16, 32); err != nil {
+			return HashInfo{}, ErrInvalidHash
+		}
+		if seed == hashInit {
+			seed = 0
+		}
+	}
+
+	blockSize, err := strconv.ParseUint(bs, 10, 32)
+	if err != nil {
+		return HashInfo{}, fmt.Errorf("%w: %w", ErrInvalidHash, err)
+	}
+
+	charset, ok := detectCharset(part1, part2)
+	if !ok {
+		return HashInfo{}, ErrInvalidHash
+	}
+
+	return HashInfo{BlockSize: uint32(blockSize), Part1: part1, Part2: part2, Seed: uint32(seed), Charset: charset}, nil
+}
+
+// Segments returns the digests computed at the block size and at twice the block size.
+// Prefer it over the Part1 and Part2 fields, which may become unexported in a future version.
+func (h HashInfo) Segments() (part1, part2 string) {
+	return h.Part1, h.Part2
+}
+
+// BlockSizeUint32 returns the block size the hash was computed with.
+// Prefer it over the BlockSize field, which may become unexported in a future version.
+func (h HashInfo) BlockSizeUint32() uint32 {
+	return h.BlockSize
+}
+
+// String returns the hash in format "blockSize:part1:part2", followed by ":seed" for seeded hashes
+func (h HashInfo) String() string {
+	s := strconv.FormatUint(uint64(h.BlockSize), 10) + ":" + h.Part1 + ":" + h.Part2
+	if h.Seed != 0 {
+		s += ":" + strconv.FormatUint(uint64(h.Seed), 16)
+	}
+	return s
+}
+
+// GoString returns h as a Go composite literal, so that %#v output can be pasted into code.
+// Seed and Charset are only included when set.
+func (h HashInfo) GoString() string {
+	s := "ssdeep.HashInfo{BlockSize: " + strconv.FormatUint(uint64(h.BlockSize), 10) +
+		", Part1: " + strconv.Quote(h.Part1) + ", Part2: " + strconv.Quote(h.Part2)
+	if h.Seed != 0 {
+		s += ", Seed: 0x" + strconv.FormatUint(uint64(h.Seed), 16)
+	}
+	if h.Charset != CharsetUnknown {
+		s += ", Charset: " + h.Charset.GoString()
+	}
+	return s + "}"
+}
+
+// Format implements fmt.Formatter: %s, %v and %q format the hash string, and %#v
+// formats GoString. Width and precision flags apply as for a string.
+func (h HashInfo) Format(f fmt.State, verb rune) {
+	switch {
+	case verb == 'v' && f.Flag('#'):
+		io.WriteString(f, h.GoString())
+	case verb == 's' || verb == 'v' || verb == 'q':
+		fmt.Fprintf(f, fmt.FormatString(f, verb), h.String())
+	default:
+		fmt.Fprintf(f, "%%!%c(ssdeep.HashInfo=%s)", verb, h.String())
+	}
+}
+
+// GoString returns the name of the Charset constant
+func (c Charset) GoString() string {
+	switch c {
+	case CharsetUnknown:
+		return "ssdeep.CharsetUnknown"
+	case CharsetStandard:
+		return "ssdeep.CharsetStandard"
+	case CharsetURLSafe:
+		return "ssdeep.CharsetURLSafe"
+	default:
+		return "ssdeep.Charset(" + strconv.Itoa(int(c)) + ")"
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (h HashInfo) MarshalText() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (h *HashInfo) UnmarshalText(text []byte) error {
+	parsed, err := Parse(string(text))
+	if err != nil {
+		return err
+	}
+
+	*h = parsed
+	return nil
+}
+
+// Record is a hash together with optional metadata tags, such as file type, scan date or
+// malware family, for hash databases. The text format of hashes does not carry metadata;
+// in JSON a Record is an object {"hash": ..., "metadata": {...}}, without "metadata"
+// when there are no tags, while a HashInfo alone is always the hash string.
+type Record struct {
+	Hash     HashInfo          `json:"hash"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// String returns the hash string of r, without its metadata.
+func (r Record) String() string {
+	return r.Hash.String()
+}
+
+// Clone returns a copy of r with its own Metadata map, so that changing the tags of
+// either does not affect the other.
+func (r Record) Clone() Record {
+	r.Metadata = maps.Clone(r.Metadata)
+	return r
+}
+
+// WithMeta returns a copy of r, as made by Clone, with the metadata key set to value.
+func (r Record) WithMeta(key, value string) Record {
+	c := r.Clone()
+	if c.Metadata == nil {
+		c.Metadata = make(map[string]string, 1)
+	}
+	c.Metadata[key] = value
+	return c
+}
+
+// Score calculates similarity score (0 to 100) between h and other, like Compare
+// but using the already parsed segments.
+// Hashes computed with different seeds are not comparable and yield ErrSeedMismatch,
+// hashes encoded with different base64 alphabets yield ErrIncompatibleCharsets.
+func (h HashInfo) Score(other HashInfo) (int, error) {
+	return compareParsed(h, other)
+}
+
+// comparable reports why h and other cannot be compared, if they cannot
+func (h HashInfo) comparable(other HashInfo) error {
+	if h.Seed != other.Seed {
+		return ErrSeedMismatch
+	}
+	if h.Charset != CharsetUnknown && other.Charset != CharsetUnknown && h.Charset != other.Charset {
+		return ErrIncompatibleCharsets
+	}
+	return nil
+}
+
+// ScoreStr parses hash and calculates its similarity score against h.
+func (h HashInfo) ScoreStr(hash string) (int, error) {
+	return compareWithParsed(h, hash)
+}
+
+// compareParsed scores two parsed hashes, so comparing in a loop needs no parsing at all
+func compareParsed(h1, h2 HashInfo) (int, error) {
+	if err := h1.comparable(h2); err != nil {
+		return 0, err
+	}
+
+	return CompareSegments(h1.Part1, h1.Part2, h2.Part1, h2.Part2, h1.BlockSize, h2.BlockSize)
+}
+
+// compareWithParsed scores an already parsed hash against a hash string, parsing only the latter
+func compareWithParsed(h1 HashInfo, hash2 string) (int, error) {
+	h2, err := Parse(hash2)
+	if err != nil {
+		return 0, err
+	}
+
+	return compareParsed(h1, h2)
+}
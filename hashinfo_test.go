@@ -0,0 +1,142 @@
+package ssdeep
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashInfoJSON(t *testing.T) {
+	type record struct {
+		Hash HashInfo
+	}
+
+	h, err := Parse("3:FJKKIUKact:FHIGi")
+	require.NoError(t, err)
+
+	data, err := json.Marshal(record{Hash: h})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"Hash":"3:FJKKIUKact:FHIGi"}`, string(data))
+
+	var decoded record
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	require.Equal(t, h, decoded.Hash)
+
+	require.Error(t, json.Unmarshal([]byte(`{"Hash":"invalid"}`), &decoded))
+}
+
+func TestRecord(t *testing.T) {
+	h, err := Parse("3:FJKKIUKact:FHIGi")
+	require.NoError(t, err)
+
+	r := Record{Hash: h}
+	tagged := r.WithMeta("type", "text").WithMeta("family", "none")
+	require.Nil(t, r.Metadata, "WithMeta should not modify its receiver")
+	require.Equal(t, map[string]string{"type": "text", "family": "none"}, tagged.Metadata)
+	require.Equal(t, "3:FJKKIUKact:FHIGi", tagged.String())
+	require.Equal(t, "3:FJKKIUKact:FHIGi", fmt.Sprint(tagged))
+
+	clone := tagged.Clone()
+	clone.Metadata["type"] = "binary"
+	require.Equal(t, "text", tagged.Metadata["type"])
+
+	// HashInfo stays comparable, e.g. as a map key
+	seen := map[HashInfo]bool{h: true}
+	require.True(t, seen[tagged.Hash])
+
+	data, err := json.Marshal(tagged)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"hash":"3:FJKKIUKact:FHIGi","metadata":{"type":"text","family":"none"}}`, string(data))
+
+	var decoded Record
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	require.Equal(t, tagged, decoded)
+
+	// Without tags the metadata is left out, and the hash is a string either way
+	data, err = json.Marshal(r)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"hash":"3:FJKKIUKact:FHIGi"}`, string(data))
+	decoded = Record{}
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	require.Equal(t, r, decoded)
+
+	require.Error(t, json.Unmarshal([]byte(`{"hash":"invalid"}`), &decoded))
+}
+
+func TestHashInfoScore(t *testing.T) {
+	h, err := Parse("3:FJKKIUKact:FHIGi")
+	require.NoError(t, err)
+	other, err := Parse("3:FJKKIrKact:FHIrGi")
+	require.NoError(t, err)
+
+	s, err := h.Score(other)
+	require.NoError(t, err)
+	require.Equal(t, 71, s)
+
+	s, err = h.ScoreStr("3:FJKKIrKact:FHIrGi")
+	require.NoError(t, err)
+	require.Equal(t, 71, s)
+
+	_, err = h.ScoreStr("invalid")
+	require.ErrorIs(t, err, ErrInvalidHash)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		_, _ = h.ScoreStr("3:FJKKIrKact:FHIrGi")
+	})
+	require.Zero(t, allocs)
+}
+
+func TestHashInfoCharset(t *testing.T) {
+	tests := []struct {
+		hash    string
+		charset Charset
+	}{
+		{"3:FJKKIUKact:FHIGi", CharsetUnknown},
+		{"3:M3+4CDTfWRcyNEqrBFWMEWM8XJ:M3KDKKqzZEL8XJ", CharsetStandard},
+		{"3:M3-4CDTfWRcyNEqrBFWMEWM8XJ:M3KDKKqzZEL8X_", CharsetURLSafe},
+	}
+	for _, tc := range tests {
+		h, err := Parse(tc.hash)
+		require.NoError(t, err)
+		require.Equal(t, tc.charset, h.Charset, "Charset mismatch for %s", tc.hash)
+	}
+
+	_, err := Parse("3:M3+4CDTfWRcyNEqrBFWMEWM8XJ:M3KDKKqzZEL8X_")
+	require.ErrorIs(t, err, ErrInvalidHash)
+
+	_, err = Compare("3:M3+4CDTfWRcyNEqrBFWMEWM8XJ:M3KDKKqzZEL8XJ", "3:M3-4CDTfWRcyNEqrBFWMEWM8XJ:M3KDKKqzZEL8X_")
+	require.ErrorIs(t, err, ErrIncompatibleCharsets)
+
+	// Hashes without distinguishing characters compare against either charset
+	_, err = Compare("3:FJKKIUKact:FHIGi", "3:M3-4CDTfWRcyNEqrBFWMEWM8XJ:M3KDKKqzZEL8X_")
+	require.NoError(t, err)
+}
+
+func TestHashInfoFormat(t *testing.T) {
+	h, err := Parse("3:FJKKIUKact:FHIGi")
+	require.NoError(t, err)
+
+	require.Equal(t, "3:FJKKIUKact:FHIGi", fmt.Sprintf("%s", h))
+	require.Equal(t, "3:FJKKIUKact:FHIGi", fmt.Sprintf("%v", h))
+	require.Equal(t, "3:FJKKIUKact:FHIGi", fmt.Sprintf("%+v", h))
+	require.Equal(t, `"3:FJKKIUKact:FHIGi"`, fmt.Sprintf("%q", h))
+	require.Equal(t, "  3:FJKKIUKact:FHIGi", fmt.Sprintf("%20s", h))
+	require.Equal(t, `ssdeep.HashInfo{BlockSize: 3, Part1: "FJKKIUKact", Part2: "FHIGi"}`, fmt.Sprintf("%#v", h))
+	require.Equal(t, "%!d(ssdeep.HashInfo=3:FJKKIUKact:FHIGi)", fmt.Sprintf("%d", h))
+
+	h, err = Parse("3:M3+4CDTfWRcyNEqrBFWMEWM8XJ:M3KDKKqzZEL8XJ:beef")
+	require.NoError(t, err)
+	require.Equal(t, `ssdeep.HashInfo{BlockSize: 3, Part1: "M3+4CDTfWRcyNEqrBFWMEWM8XJ", Part2: "M3KDKKqzZEL8XJ", Seed: 0xbeef, Charset: ssdeep.CharsetStandard}`, h.GoString())
+}
+
+func TestHashInfoAccessors(t *testing.T) {
+	h, err := Parse("3:FJKKIUKact:FHIGi")
+	require.NoError(t, err)
+
+	part1, part2 := h.Segments()
+	require.Equal(t, "FJKKIUKact", part1)
+	require.Equal(t, "FHIGi", part2)
+	require.Equal(t, uint32(3), h.BlockSizeUint32())
+}
@@ -0,0 +1,54 @@
+package ssdeep
+
+import "hash"
+
+// MultiHasher computes an ssdeep fuzzy hash alongside any number of cryptographic hashes
+// in a single pass, for pipelines that record both, e.g. a SHA-256 and an ssdeep per file.
+//
+//	sha := sha256.New()
+//	mh := ssdeep.NewMultiHasher(info.Size(), sha)
+//	io.Copy(mh, file)
+//	fuzzy, digest := mh.SSDeep(), sha.Sum(nil)
+//
+// A MultiHasher is not safe for concurrent use.
+type MultiHasher struct {
+	fuzzy  *Hasher     // Fuzzy hash for a known size
+	multi  *multiState // Fuzzy hash for an unknown size, see WithSinglePass
+	hashes []hash.Hash
+}
+
+// NewMultiHasher returns a MultiHasher for an input of size bytes, which selects the block
+// size and should be exact to match Bytes. A negative size means unknown: the digests of
+// all block sizes are then computed side by side, as with WithSinglePass, which is slower.
+// The cryptographic hashes are fed every byte written; read them with their own Sum.
+func NewMultiHasher(size int64, cryptoHashes ...hash.Hash) *MultiHasher {
+	mh := &MultiHasher{hashes: cryptoHashes}
+	if size < 0 {
+		mh.multi = newMultiState(hashInit)
+	} else {
+		mh.fuzzy = NewHasher()
+		mh.fuzzy.Reset(size)
+	}
+	return mh
+}
+
+// Write implements io.Writer, adding p to every hash. It never returns an error.
+func (mh *MultiHasher) Write(p []byte) (int, error) {
+	if mh.multi != nil {
+		mh.multi.Write(p)
+	} else {
+		mh.fuzzy.Write(p)
+	}
+	for _, h := range mh.hashes {
+		h.Write(p)
+	}
+	return len(p), nil
+}
+
+// SSDeep returns the fuzzy hash of the data written so far.
+func (mh *MultiHasher) SSDeep() string {
+	if mh.multi != nil {
+		return mh.multi.Sum()
+	}
+	return mh.fuzzy.state.Sum()
+}
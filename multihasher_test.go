@@ -0,0 +1,41 @@
+package ssdeep
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiHasher(t *testing.T) {
+	data, err := os.ReadFile("testdata/sample2.txt")
+	require.NoError(t, err)
+
+	fuzzy, err := Bytes(data)
+	require.NoError(t, err)
+	md5Sum := md5.Sum(data)
+	shaSum := sha256.Sum256(data)
+
+	for _, size := range []int64{int64(len(data)), -1} {
+		md5Hash, shaHash := md5.New(), sha256.New()
+		mh := NewMultiHasher(size, md5Hash, shaHash)
+
+		// A small buffer splits the input over many writes
+		n, err := io.CopyBuffer(mh, bytes.NewReader(data), make([]byte, 1000))
+		require.NoError(t, err)
+		require.Equal(t, int64(len(data)), n)
+
+		require.Equal(t, fuzzy, mh.SSDeep(), "size %d", size)
+		require.Equal(t, md5Sum[:], md5Hash.Sum(nil))
+		require.Equal(t, shaSum[:], shaHash.Sum(nil))
+	}
+
+	// Without cryptographic hashes it is a plain fuzzy hasher
+	mh := NewMultiHasher(int64(len(data)))
+	mh.Write(data)
+	require.Equal(t, fuzzy, mh.SSDeep())
+}
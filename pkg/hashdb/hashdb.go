@@ -0,0 +1,248 @@
+// Package hashdb stores ssdeep hashes in a SQLite database and finds the stored
+// hashes similar to a given one. It uses the pure Go modernc.org/sqlite driver,
+// so it needs no CGO.
+package hashdb
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/cosmorse/ssdeep"
+	_ "modernc.org/sqlite"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS hashes (
+	id INTEGER PRIMARY KEY,
+	hash TEXT NOT NULL,
+	path TEXT NOT NULL,
+	block_size INTEGER NOT NULL,
+	part1 TEXT NOT NULL,
+	part2 TEXT NOT NULL,
+	indexed_at DATETIME
+);
+CREATE INDEX IF NOT EXISTS hashes_block_size ON hashes (block_size);
+`
+
+// DB is a persistent database of hashes and the paths they were computed for.
+// It is safe for concurrent use.
+type DB struct {
+	db *sql.DB
+}
+
+// Open opens the database at path, creating it and its schema if needed.
+// The path ":memory:" opens a private in-memory database.
+func Open(path string) (*DB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer, and every connection to ":memory:" would
+	// open a database of its own
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("hashdb: create schema: %w", err)
+	}
+	return &DB{db: db}, nil
+}
+
+// Close closes the database.
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// Insert stores hash as computed for path. The hash must parse.
+func (d *DB) Insert(hash, path string) error {
+	return insert(d.db, hash, path)
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+func insert(db execer, hash, path string) error {
+	h, err := ssdeep.Parse(hash)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`INSERT INTO hashes (hash, path, block_size, part1, part2, indexed_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		hash, path, h.BlockSize, h.Part1, h.Part2, time.Now().UTC())
+	return err
+}
+
+// batchSize is the number of rows FindSimilarContext reads or scores between checks
+// of its context
+const batchSize = 1024
+
+// batchHook, when set by tests, is called before each check of the context by
+// FindSimilarContext, with whether it is scoring rather than reading rows and the
+// number of rows read or scored so far
+var batchHook func(scoring bool, done int)
+
+// FindSimilar returns the stored hashes scoring at least minScore against hash, in
+// insertion order. Only rows with a block size comparable to that of hash are read,
+// using the block_size index, before scoring them with ssdeep.CompareManyParsed.
+// In the results Index is the id of the row, whose path Path returns, and Match is
+// the stored hash.
+func (d *DB) FindSimilar(hash string, minScore int) ([]ssdeep.CompareResult, error) {
+	return d.FindSimilarContext(context.Background(), hash, minScore)
+}
+
+// FindSimilarContext is FindSimilar with a context that can cancel a slow search of a
+// large database. The context is checked between batches of rows, both while reading
+// and while scoring them; on cancellation the partial results are discarded and
+// ctx.Err() is returned.
+func (d *DB) FindSimilarContext(ctx context.Context, hash string, minScore int) ([]ssdeep.CompareResult, error) {
+	target, err := ssdeep.Parse(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	bs := ssdeep.CompatibleBlockSizes(int(target.BlockSize))
+	rows, err := d.db.QueryContext(ctx, `SELECT id, hash FROM hashes WHERE block_size IN (?, ?, ?) ORDER BY id`, bs[0], bs[1], bs[2])
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var (
+		ids        []int
+		hashes     []string
+		candidates []ssdeep.HashInfo
+	)
+	for n := 1; rows.Next(); n++ {
+		if n%batchSize == 0 {
+			if batchHook != nil {
+				batchHook(false, n)
+			}
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		var (
+			id     int
+			stored string
+		)
+		if err := rows.Scan(&id, &stored); err != nil {
+			return nil, err
+		}
+		h, err := ssdeep.Parse(stored)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+		hashes = append(hashes, stored)
+		candidates = append(candidates, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var results []ssdeep.CompareResult
+	for start := 0; start < len(candidates); start += batchSize {
+		if batchHook != nil {
+			batchHook(true, start)
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		batch, err := ssdeep.CompareManyParsed(target, candidates[start:min(start+batchSize, len(candidates))], minScore)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range batch {
+			i := start + r.Index
+			r.Index, r.Match = ids[i], hashes[i]
+			results = append(results, r)
+		}
+	}
+	return results, nil
+}
+
+// Path returns the path stored in the row with id, as reported by FindSimilar.
+func (d *DB) Path(id int) (string, error) {
+	var path string
+	err := d.db.QueryRow(`SELECT path FROM hashes WHERE id = ?`, id).Scan(&path)
+	return path, err
+}
+
+// ExportCSV writes every stored hash in insertion order, one `hash,"path"` line
+// each, the format printed by the ssdeep command. Paths are written unescaped, like
+// WriteHashFile does, so that ImportCSV and the official tool read them back: if a
+// stored path contains a line break, ExportCSV fails with ssdeep.ErrInvalidFileName
+// before writing anything.
+func (d *DB) ExportCSV(w io.Writer) error {
+	var path string
+	err := d.db.QueryRow(`SELECT path FROM hashes WHERE instr(path, char(10)) > 0 OR instr(path, char(13)) > 0 LIMIT 1`).Scan(&path)
+	if err == nil {
+		return fmt.Errorf("hashdb: %w: %q", ssdeep.ErrInvalidFileName, path)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	rows, err := d.db.Query(`SELECT hash, path FROM hashes ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	bw := bufio.NewWriter(w)
+	for rows.Next() {
+		var hash, path string
+		if err := rows.Scan(&hash, &path); err != nil {
+			return err
+		}
+		fmt.Fprintf(bw, "%s,\"%s\"\n", hash, path)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// ImportCSV stores the hashes read from r, in the format written by ExportCSV or
+// printed by the ssdeep command. The header line of the official ssdeep tool is
+// skipped. Either every line is imported or, on error, none is.
+func (d *DB) ImportCSV(r io.Reader) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if text == "" || strings.HasPrefix(text, "ssdeep,") {
+			continue
+		}
+		hash, path, ok := strings.Cut(text, ",")
+		if !ok {
+			return fmt.Errorf("hashdb: line %d: missing path", line)
+		}
+		// Paths are not escaped, so the closing quote is the last one on the line
+		if strings.HasPrefix(path, `"`) {
+			if end := strings.LastIndexByte(path, '"'); end > 0 {
+				path = path[1:end]
+			}
+		}
+		if err := insert(tx, hash, path); err != nil {
+			return fmt.Errorf("hashdb: line %d: %w", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
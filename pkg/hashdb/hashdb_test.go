@@ -0,0 +1,202 @@
+package hashdb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cosmorse/ssdeep"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hashes.db")
+	db, err := Open(path)
+	require.NoError(t, err)
+
+	require.NoError(t, db.Insert("3:FJKKIUKact:FHIGi", "quick.txt"))
+	require.NoError(t, db.Insert("3:FJKKIrKact:FHIrGi", "quick2.txt"))
+	require.NoError(t, db.Insert("96:bLQvsVmZnuzS3sYW5TYkBApUM6QlEarhbJ9G+0Go:bLQvsVmZ0CkBApz6QlE6N9R0n", "far.txt"))
+	require.ErrorIs(t, db.Insert("invalid", "broken.txt"), ssdeep.ErrInvalidHash)
+
+	results, err := db.FindSimilar("3:FJKKIUKact:FHIGi", 50)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	require.Equal(t, 100, results[0].Score)
+	require.Equal(t, "3:FJKKIUKact:FHIGi", results[0].Match)
+	require.Equal(t, 71, results[1].Score)
+
+	p, err := db.Path(results[1].Index)
+	require.NoError(t, err)
+	require.Equal(t, "quick2.txt", p)
+
+	_, err = db.FindSimilar("invalid", 50)
+	require.ErrorIs(t, err, ssdeep.ErrInvalidHash)
+
+	// The database persists across opens
+	require.NoError(t, db.Close())
+	db, err = Open(path)
+	require.NoError(t, err)
+	defer db.Close()
+	results, err = db.FindSimilar("3:FJKKIUKact:FHIGi", 100)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+}
+
+func TestDBCSV(t *testing.T) {
+	db, err := Open(":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+
+	const csv = "ssdeep,1.1--blocksize:hash:hash,filename\n" +
+		"3:FJKKIUKact:FHIGi,\"quick.txt\"\n" +
+		"3:FJKKIrKact:FHIrGi,\"dir/with, comma.txt\"\n"
+	require.NoError(t, db.ImportCSV(strings.NewReader(csv)))
+
+	var out bytes.Buffer
+	require.NoError(t, db.ExportCSV(&out))
+	require.Equal(t, strings.SplitN(csv, "\n", 2)[1], out.String())
+
+	// A bad line imports nothing
+	err = db.ImportCSV(strings.NewReader("3:FJKKIUKact:FHIGi,\"again.txt\"\ninvalid,\"broken.txt\"\n"))
+	require.ErrorIs(t, err, ssdeep.ErrInvalidHash)
+	require.ErrorContains(t, err, "line 2")
+	out.Reset()
+	require.NoError(t, db.ExportCSV(&out))
+	require.Equal(t, 2, strings.Count(out.String(), "\n"))
+
+	// Quotes read back, as the last quote of a line ends the path
+	require.NoError(t, db.Insert("3:FJKKIUKact:FHIGi", `say "hi".txt`))
+	out.Reset()
+	require.NoError(t, db.ExportCSV(&out))
+	copied, err := Open(":memory:")
+	require.NoError(t, err)
+	defer copied.Close()
+	require.NoError(t, copied.ImportCSV(&out))
+	path, err := copied.Path(3)
+	require.NoError(t, err)
+	require.Equal(t, `say "hi".txt`, path)
+
+	// A line break would split its line, so nothing is exported
+	require.NoError(t, db.Insert("3:FJKKIUKact:FHIGi", "two\nlines.txt"))
+	out.Reset()
+	err = db.ExportCSV(&out)
+	require.ErrorIs(t, err, ssdeep.ErrInvalidFileName)
+	require.Zero(t, out.Len())
+}
+
+func TestFindSimilarContext(t *testing.T) {
+	db, err := Open(":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+
+	var csv strings.Builder
+	for i := range 10 * batchSize {
+		fmt.Fprintf(&csv, "3:FJKKIUKact%d:FHIGi,\"file%d\"\n", i, i)
+	}
+	require.NoError(t, db.ImportCSV(strings.NewReader(csv.String())))
+
+	results, err := db.FindSimilarContext(context.Background(), "3:FJKKIUKact:FHIGi", 50)
+	require.NoError(t, err)
+	require.Len(t, results, 10*batchSize)
+	path, err := db.Path(results[1].Index)
+	require.NoError(t, err)
+	require.Equal(t, "file1", path)
+
+	// Cancelling while reading or while scoring stops at the next batch
+	defer func() { batchHook = nil }()
+	for _, scoring := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(context.Background())
+		batchHook = func(s bool, done int) {
+			if s == scoring && done >= 3*batchSize {
+				cancel()
+			}
+		}
+		results, err := db.FindSimilarContext(ctx, "3:FJKKIUKact:FHIGi", 50)
+		require.ErrorIs(t, err, context.Canceled, "scoring %v", scoring)
+		require.Nil(t, results)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = db.FindSimilarContext(ctx, "3:FJKKIUKact:FHIGi", 50)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+// randomHashes returns the hashes of n random inputs of 1 KiB to 64 KiB, spread over several block sizes
+func randomHashes(n int) []string {
+	rng := rand.New(rand.NewPCG(1, 2))
+	hashes := make([]string, n)
+	for i := range hashes {
+		data := make([]byte, 1024+rng.IntN(63*1024))
+		for j := range data {
+			data[j] = byte(rng.Uint32())
+		}
+		hashes[i] = ssdeep.SumBytes(data)
+	}
+	return hashes
+}
+
+// benchHashes are the 10000 hashes stored in the databases of the FindSimilar benchmarks
+var benchHashes = randomHashes(10000)
+
+// openBench opens a database at path holding benchHashes
+func openBench(b *testing.B, path string) *DB {
+	db, err := Open(path)
+	require.NoError(b, err)
+	b.Cleanup(func() { db.Close() })
+
+	var csv strings.Builder
+	for i, h := range benchHashes {
+		fmt.Fprintf(&csv, "%s,\"file%d\"\n", h, i)
+	}
+	require.NoError(b, db.ImportCSV(strings.NewReader(csv.String())))
+	return db
+}
+
+// BenchmarkFindSimilar searches a 10000 hash database stored in a file and in memory.
+// BenchmarkFindSimilarInMemory runs the same search without SQLite, for comparison.
+func BenchmarkFindSimilar(b *testing.B) {
+	query := benchHashes[len(benchHashes)/2]
+
+	for name, path := range map[string]string{
+		"File":   filepath.Join(b.TempDir(), "bench.db"),
+		"Memory": ":memory:",
+	} {
+		db := openBench(b, path)
+		b.Run(name, func(b *testing.B) {
+			for b.Loop() {
+				_, _ = db.FindSimilar(query, 50)
+			}
+		})
+	}
+}
+
+// BenchmarkFindSimilarInMemory is the in-memory implementation of FindSimilar that
+// BenchmarkFindSimilar is measured against: scoring a slice of the same 10000 hashes
+// with CompareMany, or with CompareManyParsed when they are parsed beforehand.
+func BenchmarkFindSimilarInMemory(b *testing.B) {
+	parsed := make([]ssdeep.HashInfo, len(benchHashes))
+	for i, h := range benchHashes {
+		var err error
+		parsed[i], err = ssdeep.Parse(h)
+		require.NoError(b, err)
+	}
+	query := benchHashes[len(benchHashes)/2]
+	target := parsed[len(benchHashes)/2]
+
+	b.Run("CompareMany", func(b *testing.B) {
+		for b.Loop() {
+			_, _ = ssdeep.CompareMany(query, benchHashes, 50)
+		}
+	})
+	b.Run("CompareManyParsed", func(b *testing.B) {
+		for b.Loop() {
+			_, _ = ssdeep.CompareManyParsed(target, parsed, 50)
+		}
+	})
+}
@@ -0,0 +1,102 @@
+package ssdeep
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// HashPool hashes files and byte slices on a fixed set of worker goroutines, bounding
+// the resources used by concurrent hash requests, e.g. in a server. It is safe for
+// concurrent use.
+type HashPool struct {
+	work      chan func()
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// NewHashPool starts a HashPool with workers goroutines, or runtime.NumCPU() when workers <= 0.
+// Close must be called to stop them.
+func NewHashPool(workers int) *HashPool {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	p := &HashPool{
+		work: make(chan func()),
+		done: make(chan struct{}),
+	}
+	for range workers {
+		p.wg.Go(p.worker)
+	}
+	return p
+}
+
+// worker runs submitted work until the pool is closed
+func (p *HashPool) worker() {
+	for {
+		select {
+		case fn := <-p.work:
+			fn()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// submit runs hash on a worker and returns its result. It blocks until a worker is
+// available and the result is ready, or ctx is done.
+func (p *HashPool) submit(ctx context.Context, hash func() (string, error)) (string, error) {
+	type result struct {
+		hash string
+		err  error
+	}
+	// Buffered, so that a worker finishing after ctx is done does not block
+	results := make(chan result, 1)
+	fn := func() {
+		h, err := hash()
+		results <- result{h, err}
+	}
+
+	select {
+	case p.work <- fn:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-p.done:
+		return "", ErrPoolClosed
+	}
+
+	select {
+	case res := <-results:
+		return res.hash, res.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// Hash computes the fuzzy hash of the file at path on a worker, like FileContext.
+func (p *HashPool) Hash(ctx context.Context, path string, options ...Option) (string, error) {
+	return p.submit(ctx, func() (string, error) {
+		return FileContext(ctx, path, options...)
+	})
+}
+
+// HashBytes computes the fuzzy hash of data on a worker, like Bytes.
+func (p *HashPool) HashBytes(ctx context.Context, data []byte) (string, error) {
+	return p.submit(ctx, func() (string, error) {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		return Bytes(data)
+	})
+}
+
+// Close stops accepting work, waits for the hashes in progress to finish and stops
+// the workers. Later calls to Hash and HashBytes return ErrPoolClosed.
+func (p *HashPool) Close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+	})
+	p.wg.Wait()
+}
@@ -0,0 +1,79 @@
+package ssdeep
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashPool(t *testing.T) {
+	dir := t.TempDir()
+	var (
+		paths    []string
+		expected []string
+	)
+	for i := range 10 {
+		data := []byte(fmt.Sprintf("file %d: The quick brown fox jumps over the lazy dog %d times", i, i*i))
+		path := filepath.Join(dir, fmt.Sprintf("file%d", i))
+		require.NoError(t, os.WriteFile(path, data, 0o600))
+
+		hash, err := Bytes(data)
+		require.NoError(t, err)
+		paths = append(paths, path)
+		expected = append(expected, hash)
+	}
+
+	pool := NewHashPool(4)
+	defer pool.Close()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 100)
+	for i := range 100 {
+		wg.Go(func() {
+			var (
+				hash string
+				err  error
+			)
+			if i%2 == 0 {
+				hash, err = pool.Hash(context.Background(), paths[i%len(paths)])
+			} else {
+				hash, err = pool.HashBytes(context.Background(), []byte("The quick brown fox jumps over the lazy dog"))
+			}
+			switch {
+			case err != nil:
+				errs[i] = err
+			case i%2 == 0 && hash != expected[i%len(paths)]:
+				errs[i] = fmt.Errorf("call %d: got %s, want %s", i, hash, expected[i%len(paths)])
+			case i%2 == 1 && hash != "3:FJKKIUKact:FHIGi":
+				errs[i] = fmt.Errorf("call %d: got %s", i, hash)
+			}
+		})
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+}
+
+func TestHashPoolCancelAndClose(t *testing.T) {
+	pool := NewHashPool(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := pool.HashBytes(ctx, []byte("data"))
+	require.ErrorIs(t, err, context.Canceled)
+
+	_, err = pool.Hash(context.Background(), filepath.Join(t.TempDir(), "missing"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	pool.Close()
+	pool.Close()
+	_, err = pool.HashBytes(context.Background(), []byte("data"))
+	require.ErrorIs(t, err, ErrPoolClosed)
+}
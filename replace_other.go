@@ -0,0 +1,23 @@
+//go:build !windows
+
+package ssdeep
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// replaceFile atomically renames oldpath to newpath, replacing any file there, and
+// syncs the directory so that the rename itself survives a crash
+func replaceFile(oldpath, newpath string) error {
+	if err := os.Rename(oldpath, newpath); err != nil {
+		return err
+	}
+
+	// Some filesystems cannot sync directories; the rename is still atomic
+	if dir, err := os.Open(filepath.Dir(newpath)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
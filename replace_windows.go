@@ -0,0 +1,18 @@
+package ssdeep
+
+import "golang.org/x/sys/windows"
+
+// replaceFile renames oldpath to newpath, replacing any file there. os.Rename is not
+// atomic when newpath exists, so MoveFileEx replaces it in one operation, returning
+// only once the move has been flushed to disk.
+func replaceFile(oldpath, newpath string) error {
+	from, err := windows.UTF16PtrFromString(oldpath)
+	if err != nil {
+		return err
+	}
+	to, err := windows.UTF16PtrFromString(newpath)
+	if err != nil {
+		return err
+	}
+	return windows.MoveFileEx(from, to, windows.MOVEFILE_REPLACE_EXISTING|windows.MOVEFILE_WRITE_THROUGH)
+}
@@ -0,0 +1,82 @@
+package ssdeep
+
+import "fmt"
+
+// selfTestVectors are inputs with their known hashes. The text inputs are the official
+// test vectors; the generated ones cover larger block sizes without embedding big files.
+var selfTestVectors = []struct {
+	name string
+	data func() []byte
+	hash string
+}{
+	{
+		name: "sample1",
+		data: func() []byte { return []byte("The quick brown fox jumps over the lazy dog") },
+		hash: "3:FJKKIUKact:FHIGi",
+	},
+	{
+		name: "sample2",
+		data: func() []byte { return []byte("A completely different string that should have no similarity") },
+		hash: "3:M3+4CDTfWRcyNEqrBFWMEWM8XJ:M3KDKKqzZEL8XJ",
+	},
+	{
+		name: "xorshift 4KiB",
+		data: func() []byte { return selfTestData(4 << 10) },
+		hash: "96:bLQvsVmZnuzS3sYW5TYkBApUM6QlEarhbJ9G+0Go:bLQvsVmZ0CkBApz6QlE6N9R0n",
+	},
+	{
+		name: "xorshift 1MiB",
+		data: func() []byte { return selfTestData(1 << 20) },
+		hash: "24576:4KMZ1qUxMSiS78xkuJzKiSelxH0siLMDpOR5NP8:4KMjqUxMSiS7gkuJGVSFM4V",
+	},
+}
+
+// selfTestScores are hash pairs with their known similarity scores
+var selfTestScores = []struct {
+	h1, h2 string
+	score  int
+}{
+	{"3:FJKKIUKact:FHIGi", "3:FJKKIrKact:FHIrGi", 71},
+	{"3:FJKKIUKact:FHIGi", "3:AXA:B", 0},
+}
+
+// selfTestData returns n deterministic pseudo-random bytes from a xorshift32 generator
+func selfTestData(n int) []byte {
+	data := make([]byte, n)
+	x := uint32(2463534242)
+	for i := range data {
+		x ^= x << 13
+		x ^= x >> 17
+		x ^= x << 5
+		data[i] = byte(x)
+	}
+	return data
+}
+
+// SelfTest hashes a set of embedded inputs and compares a set of hash pairs, returning
+// an error describing the first result that differs from its known value. It lets
+// programs vendoring this package assert at runtime that the build produces correct
+// output, e.g. in CI after enabling a different optimization.
+func SelfTest() error {
+	for _, v := range selfTestVectors {
+		hash, err := Bytes(v.data())
+		if err != nil {
+			return fmt.Errorf("ssdeep: self-test %s: %w", v.name, err)
+		}
+		if hash != v.hash {
+			return fmt.Errorf("ssdeep: self-test %s: got hash %q, want %q", v.name, hash, v.hash)
+		}
+	}
+
+	for _, v := range selfTestScores {
+		score, err := Compare(v.h1, v.h2)
+		if err != nil {
+			return fmt.Errorf("ssdeep: self-test compare %s %s: %w", v.h1, v.h2, err)
+		}
+		if score != v.score {
+			return fmt.Errorf("ssdeep: self-test compare %s %s: got score %d, want %d", v.h1, v.h2, score, v.score)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,29 @@
+package ssdeep
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelfTest(t *testing.T) {
+	require.NoError(t, SelfTest())
+
+	// The text vectors are the official test data
+	for _, v := range selfTestVectors[:2] {
+		data, err := os.ReadFile("testdata/" + v.name + ".txt")
+		require.NoError(t, err)
+		require.Equal(t, data, v.data())
+	}
+}
+
+func TestSelfTestMismatch(t *testing.T) {
+	saved := selfTestVectors[0].hash
+	t.Cleanup(func() { selfTestVectors[0].hash = saved })
+
+	selfTestVectors[0].hash = "3:FJKKIUKact:FHIGj"
+	err := SelfTest()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "sample1")
+}
@@ -0,0 +1,160 @@
+package ssdeep
+
+import (
+	"io"
+	"math/bits"
+)
+
+// singlePassLevels is the number of block sizes tracked by multiState: every
+// minBlockSize times a power of two that fits in a uint32, plus the wrapped
+// double of the largest one, which is the second segment of its hash
+const singlePassLevels = 32
+
+// multiState computes the digests of all block sizes at once. The rolling hash does
+// not depend on the block size, and a boundary at a block size is also a boundary at
+// every smaller one, so each level only adds its own piecewise hash and digest.
+// Level k has block size minBlockSize<<k; the hash for block size level k is made of
+// the digests of levels k and k+1, just like hash1 and hash2 of an ssdeepState.
+type multiState struct {
+	// Rolling hash state, as in ssdeepState
+	h1, h2, h3 uint32
+	window     [windowSize]byte
+	n          uint64
+
+	seed uint32
+	lo   int // Lowest level that the total size can still select
+
+	p      [singlePassLevels]uint32            // Piecewise hash value per level
+	half   [singlePassLevels]uint32            // Piecewise hash value per level as a second segment
+	digest [singlePassLevels][part1Chunks]byte // Digest characters per level
+	length [singlePassLevels]int               // Characters used in digest per level
+	tail   [singlePassLevels]byte              // Character at the last boundary past part1Chunks
+	tail2  [singlePassLevels]byte              // Character at the last boundary past part2Chunks
+}
+
+func newMultiState(seed uint32) *multiState {
+	ms := &multiState{seed: seed}
+	for k := range ms.p {
+		ms.p[k] = seed
+		ms.half[k] = seed
+	}
+	return ms
+}
+
+// Write implements io.Writer, updating the rolling hash once and the piecewise hash of
+// every level still in the running. Levels below the block size estimated for the bytes
+// seen so far can never be selected, since the estimate only grows, and are dropped.
+// Each level keeps two piecewise hashes, as a segment stops resetting its hash after
+// part1Chunks characters as a first segment and after part2Chunks as a second one.
+func (ms *multiState) Write(p []byte) (int, error) {
+	h1, h2, h3 := ms.h1, ms.h2, ms.h3
+	seed := ms.seed
+	lo := ms.lo
+	winIdx := uint32(ms.n % windowSize)
+	pk, half := ms.p, ms.half // Local copies, kept out of memory shared with ms
+	active, activeHalf := pk[lo:], half[lo:]
+
+	for _, c := range p {
+		u_c := uint32(c)
+
+		// Same rolling hash update as ssdeepState.Write
+		h2 -= h1
+		h2 += windowSize * u_c
+
+		h1 += u_c
+		h1 -= uint32(ms.window[winIdx])
+
+		ms.window[winIdx] = c
+		winIdx++
+		if winIdx == windowSize {
+			winIdx = 0
+		}
+
+		h3 <<= 5
+		h3 ^= u_c
+
+		for k := range active {
+			active[k] = (active[k] * 16777619) ^ u_c
+			activeHalf[k] = (activeHalf[k] * 16777619) ^ u_c
+		}
+
+		// Checking the levels in order until one misses reproduces the nesting of
+		// ssdeepState.Write, where blockSize*2 is only checked on a blockSize boundary
+		h := h1 + h2 + h3
+		for k := lo; k < singlePassLevels; k++ {
+			bs := uint32(minBlockSize) << k
+			if h%bs != bs-1 {
+				break
+			}
+			n := ms.length[k]
+			if n < part1Chunks {
+				ms.digest[k][n] = base64Chars[pk[k]%64]
+				ms.length[k]++
+				pk[k] = seed
+			} else {
+				ms.tail[k] = base64Chars[pk[k]%64]
+			}
+			if n < part2Chunks {
+				half[k] = seed
+			} else {
+				ms.tail2[k] = base64Chars[half[k]%64]
+			}
+		}
+	}
+
+	ms.h1, ms.h2, ms.h3 = h1, h2, h3
+	ms.p, ms.half = pk, half
+	ms.n += uint64(len(p))
+	ms.lo = singlePassLevel(estimateBlockSize(int64(ms.n)))
+
+	return len(p), nil
+}
+
+// singlePassLevel returns the level of block size bs
+func singlePassLevel(bs uint32) int {
+	return bits.TrailingZeros32(bs / minBlockSize)
+}
+
+// Sum returns the hash for the block size estimated from the bytes written
+func (ms *multiState) Sum() string {
+	bs := estimateBlockSize(int64(ms.n))
+	k := singlePassLevel(bs)
+	state := ssdeepState{
+		blockSize: bs,
+		h1:        ms.h1,
+		h2:        ms.h2,
+		h3:        ms.h3,
+		seed:      ms.seed,
+		p1:        ms.p[k],
+		p2:        ms.half[k+1],
+		hash1:     ms.digest[k][:ms.length[k]],
+		hash2:     ms.digest[k+1][:min(ms.length[k+1], part2Chunks)],
+		tail1:     ms.tail[k],
+		tail2:     ms.tail2[k+1],
+	}
+	return state.Sum()
+}
+
+// sumSinglePass hashes r, whose size is unknown, without caching it (see WithSinglePass)
+func (opts *hashOptions) sumSinglePass(r io.Reader) (string, error) {
+	// A forced block size does not depend on the size, so a single state is enough
+	if opts.blockSize != 0 {
+		state := opts.newState(opts.blockSize)
+		defer state.Close()
+
+		if _, err := io.Copy(state, r); err != nil {
+			return "", err
+		}
+		return state.Sum(), nil
+	}
+
+	seed := uint32(hashInit)
+	if opts.seed != 0 {
+		seed = opts.seed
+	}
+	ms := newMultiState(seed)
+	if _, err := io.Copy(ms, r); err != nil {
+		return "", err
+	}
+	return ms.Sum(), nil
+}
@@ -0,0 +1,79 @@
+package ssdeep
+
+import (
+	"bytes"
+	"io"
+	"math/rand/v2"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// requireSinglePassMatches checks that hashing data in a single pass gives the two-pass result.
+// MultiReader hides Seek, so that both take the unknown-size path.
+func requireSinglePassMatches(t *testing.T, data []byte, options ...Option) {
+	t.Helper()
+
+	want, wantErr := Stream(io.MultiReader(bytes.NewReader(data)), options...)
+	got, err := Stream(io.MultiReader(bytes.NewReader(data)), append(options, WithSinglePass())...)
+	require.Equal(t, wantErr, err)
+	require.Equal(t, want, got, "%d bytes", len(data))
+}
+
+func TestSinglePass(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	for _, size := range []int{0, 1, 7, 191, 192, 193, 4096, 100000, 3 << 20} {
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(rng.Uint32())
+		}
+		requireSinglePassMatches(t, data)
+		requireSinglePassMatches(t, data, WithHashSeed(0xbeef))
+		requireSinglePassMatches(t, data, WithBlockSize(48))
+	}
+
+	data, err := os.ReadFile("testdata/sample1.txt")
+	require.NoError(t, err)
+	requireSinglePassMatches(t, data)
+
+	// Inputs of known size are unaffected
+	want, err := Bytes(data)
+	require.NoError(t, err)
+	got, err := Stream(bytes.NewReader(data), WithSinglePass())
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+}
+
+func FuzzSinglePass(f *testing.F) {
+	f.Add([]byte("The quick brown fox jumps over the lazy dog"))
+	f.Add(bytes.Repeat([]byte("abcdefgh"), 1000))
+	f.Add(make([]byte, 10000))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		requireSinglePassMatches(t, data)
+	})
+}
+
+func BenchmarkSinglePass(b *testing.B) {
+	data := make([]byte, 16<<20)
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := range data {
+		data[i] = byte(rng.Uint32())
+	}
+
+	for _, bc := range []struct {
+		name    string
+		options []Option
+	}{
+		{"TwoPass", nil},
+		{"SinglePass", []Option{WithSinglePass()}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for b.Loop() {
+				_, _ = Stream(io.MultiReader(bytes.NewReader(data)), bc.options...)
+			}
+		})
+	}
+}
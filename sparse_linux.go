@@ -0,0 +1,109 @@
+package ssdeep
+
+import (
+	"errors"
+	"io"
+	"math"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// sparseFileReader returns a reader for a freshly opened file that skips the holes
+// of sparse files with SEEK_DATA/SEEK_HOLE, yielding zeros for them without reading
+// the disk. The bytes produced, and so the hash, are the same as reading the file.
+// Files without holes are returned as is.
+func sparseFileReader(file *os.File, info os.FileInfo) io.Reader {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || st.Blocks*512 >= info.Size() {
+		return file
+	}
+	return &sparseReader{file: file}
+}
+
+// sparseReader reads a file region by region, starting at offset 0
+type sparseReader struct {
+	file *os.File
+	pos  int64 // Offset of the next byte to return
+	next int64 // End of the region containing pos
+	hole bool  // Whether the region containing pos is a hole
+}
+
+func (r *sparseReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if r.pos >= r.next {
+		if err := r.locate(); err != nil {
+			return 0, err
+		}
+		if r.pos >= r.next {
+			return 0, io.EOF
+		}
+	}
+
+	n := int(min(int64(len(p)), r.next-r.pos))
+	if r.hole {
+		clear(p[:n])
+		r.pos += int64(n)
+		return n, nil
+	}
+
+	n, err := r.file.ReadAt(p[:n], r.pos)
+	r.pos += int64(n)
+	if n > 0 && errors.Is(err, io.EOF) {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek moves to offset like (*os.File).Seek, so that the file can be hashed again
+func (r *sparseReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		end, err := r.file.Seek(0, io.SeekEnd)
+		if err != nil {
+			return 0, err
+		}
+		offset += end
+	}
+	if offset < 0 {
+		return 0, errors.New("ssdeep: seek before start of file")
+	}
+	// The region containing the new offset is located by the next Read
+	r.pos, r.next = offset, offset
+	return offset, nil
+}
+
+// locate finds the data region or hole starting at pos
+func (r *sparseReader) locate() error {
+	fd := int(r.file.Fd())
+	data, err := unix.Seek(fd, r.pos, unix.SEEK_DATA)
+	switch {
+	case errors.Is(err, unix.ENXIO):
+		// No data after pos: the rest of the file, if any, is a hole
+		end, err := r.file.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+		r.next, r.hole = end, true
+		return nil
+	case err != nil:
+		// The filesystem cannot report holes; read the rest as data
+		r.next, r.hole = math.MaxInt64, false
+		return nil
+	case data > r.pos:
+		r.next, r.hole = data, true
+		return nil
+	}
+
+	hole, err := unix.Seek(fd, data, unix.SEEK_HOLE)
+	if err != nil {
+		hole = math.MaxInt64
+	}
+	r.next, r.hole = hole, false
+	return nil
+}
@@ -0,0 +1,65 @@
+package ssdeep
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeSparse creates a file of size bytes holding data at each given offset and holes elsewhere
+func writeSparse(t *testing.T, size int64, offsets ...int64) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "sparse")
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	require.NoError(t, file.Truncate(size))
+	chunk := make([]byte, 64<<10)
+	for i, off := range offsets {
+		for j := range chunk {
+			chunk[j] = byte(i*31 + j*7)
+		}
+		_, err := file.WriteAt(chunk, off)
+		require.NoError(t, err)
+	}
+	return path
+}
+
+func TestSparseFile(t *testing.T) {
+	for name, offsets := range map[string][]int64{
+		"leading and trailing holes": {4 << 20, 9 << 20},
+		"data at both ends":          {0, 5 << 20, 16<<20 - 64<<10},
+		"all hole":                   nil,
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := writeSparse(t, 16<<20, offsets...)
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+
+			file, err := os.Open(path)
+			require.NoError(t, err)
+			defer file.Close()
+			info, err := file.Stat()
+			require.NoError(t, err)
+
+			r := sparseFileReader(file, info)
+			if _, ok := r.(*sparseReader); !ok {
+				t.Skip("filesystem does not create sparse files")
+			}
+			got, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.Equal(t, data, got)
+
+			expected, err := Bytes(data)
+			require.NoError(t, err)
+			hash, err := File(path)
+			require.NoError(t, err)
+			require.Equal(t, expected, hash)
+		})
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package ssdeep
+
+import (
+	"io"
+	"os"
+)
+
+// sparseFileReader returns file; skipping holes relies on SEEK_DATA/SEEK_HOLE, used on Linux only
+func sparseFileReader(file *os.File, info os.FileInfo) io.Reader {
+	return file
+}
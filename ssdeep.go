@@ -5,16 +5,15 @@ package ssdeep
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"strconv"
-	"strings"
 	"sync"
-	"syscall"
-
-	"golang.org/x/sys/unix"
 )
 
 const (
@@ -24,23 +23,107 @@ const (
 	windowSize = 7
 	// spamSumLength is the maximum length of hash segments (typically 64 characters)
 	spamSumLength = 64
+	// part1Chunks is the number of characters of single chunks in the first segment. As in
+	// the official tool, the last character covers all the data after them.
+	part1Chunks = spamSumLength - 1
+	// part2Chunks is part1Chunks for the second segment, which the official tool truncates
+	// to half the length of the first
+	part2Chunks = spamSumLength/2 - 1
+	// minHashSegmentLen is the shortest segment, after shrinking runs, that can score above 0.
+	// Segments shorter than windowSize produce unreliable boundary hits and are excluded by the official algorithm.
+	minHashSegmentLen = windowSize
+	// shortSegmentPenaltyLen is the segment length below which the default short penalty caps
+	// the score, since short segments share characters by chance more easily
+	shortSegmentPenaltyLen = 11
+	// shortSegmentPenaltyDivisor divides 100 times the shorter segment length into that cap
+	shortSegmentPenaltyDivisor = 14
+	// maxSegmentLength is the longest hash segment Compare accepts. Segments up to this length from
+	// CTPH variants emitting more than spamSumLength characters are compared without truncation.
+	maxSegmentLength = 4 * spamSumLength
 	// base64Chars is the character set used for hash output encoding
 	base64Chars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
 	// hashInit is the initial value for piecewise hash (compatible with official implementation)
 	hashInit = 0x01234567
 
+	// crossBlockSizeWeight is the percentage of the score kept by CompareWeighted for hashes with 2x block size ratio
+	crossBlockSizeWeight = 80
+
+	// algorithmVersion is the official ssdeep release whose hashes and scores this package reproduces
+	algorithmVersion = "ssdeep 2.14"
+
 	defaultCachedSize = 4 << 20
 	minCachedSize     = 128 << 10
+	// minStrictCachedSize is the smallest cache WithCachedSizeStrict honors, so that
+	// spilling still writes the temporary file in chunks of a few pages
+	minStrictCachedSize = 4 << 10
 )
 
 var (
+	// ErrEmptyData is returned when hashing empty input, which has no meaningful fuzzy hash
 	ErrEmptyData = fmt.Errorf("ssdeep: empty data")
+	// ErrInvalidHash is returned for a hash string that is not in "blockSize:part1:part2"
+	// form, such as one with a missing segment or a block size that is not a number
+	ErrInvalidHash = fmt.Errorf("ssdeep: invalid hash format")
+	// ErrSeedMismatch is returned when comparing hashes computed with different WithHashSeed seeds
+	ErrSeedMismatch = fmt.Errorf("ssdeep: hashes computed with different seeds")
+	// ErrIncompatibleCharsets is returned when comparing a standard ("+/") hash with a URL-safe ("-_") one
+	ErrIncompatibleCharsets = fmt.Errorf("ssdeep: hashes use different base64 charsets")
+	// ErrIncompatibleBlockSizes is returned together with a score of 0 when comparing hashes whose
+	// block sizes are neither equal nor 2x apart. They cannot be compared, which is not the same
+	// as being found dissimilar, although hashes of similar inputs rarely end up that far apart.
+	ErrIncompatibleBlockSizes = fmt.Errorf("ssdeep: block sizes differ by more than 2x")
+	// ErrSegmentTooLong is returned when comparing a hash with a segment longer than maxSegmentLength
+	ErrSegmentTooLong = fmt.Errorf("ssdeep: hash segment too long")
+	// ErrSizeMismatch is returned when a file's data length differs from its size reported
+	// by Stat, typically because it grew or shrank while being hashed
+	ErrSizeMismatch = fmt.Errorf("ssdeep: data size differs from reported file size")
+	// ErrInvalidBlockSize is returned when WithBlockSize is given a block size that is not
+	// minBlockSize times a power of two
+	ErrInvalidBlockSize = fmt.Errorf("ssdeep: invalid block size")
+	// ErrPoolClosed is returned when submitting work to a closed HashPool
+	ErrPoolClosed = fmt.Errorf("ssdeep: hash pool closed")
+	// ErrHasherClosed is returned when writing to or closing a Hasher that was already closed
+	ErrHasherClosed = fmt.Errorf("ssdeep: hasher closed")
+	// ErrHashNotFinalized is returned by Hasher.Sum before the Hasher is closed
+	ErrHashNotFinalized = fmt.Errorf("ssdeep: hash not finalized")
+	// ErrInvalidEncoding is returned by DecodeString for data that Encode
+	// cannot have produced, such as truncated data or an out of range block size
+	ErrInvalidEncoding = fmt.Errorf("ssdeep: invalid encoded hash")
+	// ErrInvalidFileName is returned by WriteHashFile, and by the ExportCSV method of
+	// pkg/hashdb, for a file name containing a line break, which the one record per line
+	// of hash files cannot hold
+	ErrInvalidFileName = fmt.Errorf("ssdeep: file name cannot be stored in a hash file")
+	// ErrTransformationMismatch is returned by CompareTransformed for hash lists of different
+	// lengths, which cannot have been computed with the same transformations
+	ErrTransformationMismatch = fmt.Errorf("ssdeep: hash lists computed with different transformations")
 )
 
+// AlgorithmVersion returns the official ssdeep version this implementation is compatible with.
+// Hashes computed by builds reporting the same algorithm version are comparable.
+func AlgorithmVersion() string {
+	return algorithmVersion
+}
+
 type hashOptions struct {
 	size       int64
 	cachedSize int64
+	strictSize bool // cachedSize may be below minCachedSize, see WithCachedSizeStrict
 	cleanup    bool
+	keepSpill  *string
+	noAtime    bool
+	seed       uint32
+	blockSize  uint32 // forced block size, 0 to estimate it from the size
+	noPool     bool   // allocate a fresh state instead of using ssdeepStatePool
+	verifySize bool   // size came from Stat and must match the bytes read
+	singlePass bool   // hash streams of unknown size in one pass instead of caching them
+	adaptive   bool   // rehash at half the block size while the first segment is short, as the official tool does
+	ctx        context.Context
+
+	// Comparison options
+	bothDirections  bool         // compare both segment pairings for 2x block sizes
+	damerau         bool         // count adjacent transpositions as a single edit
+	shortPenalty    ShortPenalty // score cap for short segments, if shortPenaltySet
+	shortPenaltySet bool
 }
 
 type Option interface {
@@ -65,14 +148,32 @@ type cachedSizeOption int64
 func (o cachedSizeOption) apply(h *hashOptions) {
 	if o > minBlockSize {
 		h.cachedSize = int64(o)
+		h.strictSize = false
 	}
 }
 
 // WithCachedSize option allows specifying a cached size for the hash.
+// Streams of unknown size are cached in memory up to at least 128 KiB,
+// even when size is smaller.
 func WithCachedSize(size int64) Option {
 	return cachedSizeOption(size)
 }
 
+type strictCachedSizeOption int64
+
+func (o strictCachedSizeOption) apply(h *hashOptions) {
+	h.cachedSize = max(int64(o), minStrictCachedSize)
+	h.strictSize = true
+}
+
+// WithCachedSizeStrict option is WithCachedSize without the 128 KiB floor: streams of
+// unknown size longer than size spill to a temporary file, for sizes down to 4 KiB.
+// It exists to exercise the spill path in tests and to bound memory tightly; a small
+// cache makes most streams go through the disk, which is much slower than memory.
+func WithCachedSizeStrict(size int64) Option {
+	return strictCachedSizeOption(size)
+}
+
 type cleanupOption bool
 
 func (o cleanupOption) apply(h *hashOptions) {
@@ -84,11 +185,179 @@ func WithCleanup() Option {
 	return cleanupOption(true)
 }
 
+type keepSpillOption struct {
+	path *string
+}
+
+func (o keepSpillOption) apply(h *hashOptions) {
+	h.keepSpill = o.path
+}
+
+// WithKeepSpill option is a debugging aid that preserves the temporary file a
+// non-seekable stream spills to, instead of removing it on completion. Its location
+// is stored in *path, or "" if the stream fit in memory and nothing was spilled.
+// The file holds a verbatim copy of the hashed data and is left readable in the
+// temporary directory, so never use this option with sensitive input in production.
+// The caller is responsible for removing the file. WithCleanup has no effect with it.
+func WithKeepSpill(path *string) Option {
+	return keepSpillOption{path: path}
+}
+
+type seedOption uint32
+
+func (o seedOption) apply(h *hashOptions) {
+	h.seed = uint32(o)
+}
+
+// WithHashSeed option replaces the initial piecewise hash value hashInit with seed,
+// giving each seed its own hash space, e.g. to keep "PE section" and "full file"
+// hashes from matching each other. Seeded hashes carry the seed in hex as a fourth
+// field ("blockSize:hash1:hash2:seed"); they are incompatible with standard ssdeep
+// tools, and Compare returns ErrSeedMismatch for hashes with different seeds.
+// A seed of 0 or hashInit selects the standard hash.
+func WithHashSeed(seed uint32) Option {
+	return seedOption(seed)
+}
+
+type blockSizeOption uint32
+
+func (o blockSizeOption) apply(h *hashOptions) {
+	h.blockSize = uint32(o)
+}
+
+// WithBlockSize option forces the block size instead of estimating it from the data size,
+// e.g. to reproduce a hash from another tool or to align the hashes of a set of files.
+// bs must be minBlockSize times a power of two (3, 6, 12, ...), or hashing fails with
+// ErrInvalidBlockSize. Hashes only compare when block sizes are equal or 2x apart, so
+// forced-size hashes may not compare well to auto-sized hashes of very different inputs.
+func WithBlockSize(bs uint32) Option {
+	return blockSizeOption(bs)
+}
+
+type noPoolOption bool
+
+func (o noPoolOption) apply(h *hashOptions) {
+	h.noPool = bool(o)
+}
+
+// WithNoPool option allocates a fresh hash state for the call instead of reusing one
+// from the shared pool, so that memory profiles show the true allocations per hash
+// without pool reuse noise. It is a diagnostic knob, not meant for production use.
+func WithNoPool() Option {
+	return noPoolOption(true)
+}
+
+type singlePassOption bool
+
+func (o singlePassOption) apply(h *hashOptions) {
+	h.singlePass = bool(o)
+}
+
+// WithSinglePass option hashes streams of unknown size in a single pass instead of
+// caching them in memory or a temporary file and reading them again. The digests of
+// every candidate block size are computed side by side and the one matching the total
+// size is kept at EOF, so the result is identical to the two-pass hash. It needs no
+// memory or disk proportional to the input, at the cost of an extra multiply per byte
+// for each block size still in the running, which makes hashing several times slower.
+// Inputs of known size, such as files and seekable readers, are hashed in one pass
+// anyway and are unaffected.
+func WithSinglePass() Option {
+	return singlePassOption(true)
+}
+
+type adaptiveOption bool
+
+func (o adaptiveOption) apply(h *hashOptions) {
+	h.adaptive = bool(o)
+}
+
+// WithAdaptiveBlockSize option applies the block size rule of the official ssdeep tool:
+// while fewer than spamSumLength/2 (32) chunk boundaries were hit at the block size
+// estimated from the input size, as happens for repetitive inputs, the input is hashed
+// again at half the block size, down to the minimum block size. Without this option the
+// estimated block size is kept, which for such inputs yields a shorter first segment and
+// a larger block size than the official tool.
+//
+// The retry reads the input again: streams of unknown size are replayed from their cache,
+// inputs of known size must be seekable, and are hashed once otherwise. It has no effect
+// with WithBlockSize or WithSinglePass.
+func WithAdaptiveBlockSize() Option {
+	return adaptiveOption(true)
+}
+
+type bothDirectionsOption bool
+
+func (o bothDirectionsOption) apply(h *hashOptions) {
+	h.bothDirections = bool(o)
+}
+
+type damerauOption bool
+
+func (o damerauOption) apply(h *hashOptions) {
+	h.damerau = bool(o)
+}
+
+// WithDamerauLevenshtein option makes CompareWithOptions measure the distance between
+// segments with the Damerau-Levenshtein distance (optimal string alignment), which counts
+// a swap of two adjacent characters as one edit instead of two. Variants with swapped
+// adjacent regions then score higher. The scores are not compatible with standard ssdeep
+// tools, so they should only be compared with scores computed the same way.
+func WithDamerauLevenshtein() Option {
+	return damerauOption(true)
+}
+
+// WithHashBothDirections option makes CompareWithOptions evaluate both segment pairings
+// when block sizes differ by 2x and return the higher score. Standard ssdeep only pairs
+// the segments computed at the same block size; the extra pairing compares segments
+// whose block sizes differ by 4x, which favors recall over precision and deviates from
+// the scores of the official tool.
+func WithHashBothDirections() Option {
+	return bothDirectionsOption(true)
+}
+
+// ShortPenalty caps the score of short segments, which match by chance more easily:
+// when either segment, after shrinking runs of repeated characters, is shorter than
+// Length characters, the score is at most the shorter length * 100 / Divisor.
+// The reference implementation uses Length 11 and Divisor 14.
+type ShortPenalty struct {
+	Length  int // Segments shorter than this are capped; 0 disables the penalty
+	Divisor int // Divides the shorter length * 100 into the cap; must be positive
+}
+
+type shortPenaltyOption ShortPenalty
+
+func (o shortPenaltyOption) apply(h *hashOptions) {
+	h.shortPenalty = ShortPenalty(o)
+	h.shortPenaltySet = true
+}
+
+// WithShortPenalty option makes CompareWithOptions cap the scores of short segments with
+// p instead of the reference penalty, to tune the penalty curve for research. A larger
+// Divisor lowers the cap and a larger Length extends it to longer segments. The scores
+// are not compatible with standard ssdeep tools, unless p is the reference penalty.
+func WithShortPenalty(p ShortPenalty) Option {
+	return shortPenaltyOption(p)
+}
+
+type noAtimeOption bool
+
+func (o noAtimeOption) apply(h *hashOptions) {
+	h.noAtime = bool(o)
+}
+
+// WithNoAtime option opens files without updating their access time, so hashing
+// leaves file metadata untouched. On Linux it opens with O_NOATIME; when the process
+// does not own the file the kernel rejects that flag with EPERM, and the file is
+// silently opened the regular way instead. On other platforms it is a no-op.
+func WithNoAtime() Option {
+	return noAtimeOption(true)
+}
+
 var ssdeepStatePool = sync.Pool{
 	New: func() any {
 		return &ssdeepState{
-			hash1: make([]byte, 0, spamSumLength+1),
-			hash2: make([]byte, 0, spamSumLength+1),
+			hash1: make([]byte, 0, hashBufferCap),
+			hash2: make([]byte, 0, hashBufferCap),
 		}
 	},
 }
@@ -102,27 +371,53 @@ var ssdeepStatePool = sync.Pool{
 //   - n: total processed bytes count (for window indexing)
 //   - p1/p2: current piecewise hash states for blockSize and blockSize*2 respectively
 //   - res1/res2: string digest results for two scales (mapped to base64Chars characters)
+//   - tail1/tail2: last character of a segment past its single chunk characters (see Sum)
+//   - triggers1/triggers2: boundaries hit at each scale, for diagnostics (see Debug)
 type ssdeepState struct {
 	blockSize uint32 // Current chunk size used
 
 	// Rolling hash state
 	h1, h2, h3 uint32           // Three components of rolling hash
 	window     [windowSize]byte // Sliding window buffer
-	n          uint32           // Number of bytes processed, used for window index
+	n          uint64           // Number of bytes processed, used for window index
 
 	// Piecewise hash state
-	p1 uint32 // Piecewise hash value for blockSize
-	p2 uint32 // Piecewise hash value for blockSize * 2
+	seed uint32 // Initial piecewise hash value, hashInit unless seeded
+	p1   uint32 // Piecewise hash value for blockSize
+	p2   uint32 // Piecewise hash value for blockSize * 2
 
 	// Result hash buffer
 	hash1 []byte // Hash string corresponding to blockSize
 	hash2 []byte // Hash string corresponding to blockSize * 2
+	tail1 byte   // Character at the last boundary past part1Chunks, 0 if none
+	tail2 byte   // Character at the last boundary past part2Chunks, 0 if none
+
+	// Diagnostic counters
+	triggers1 uint64 // Boundaries hit at blockSize
+	triggers2 uint64 // Boundaries hit at blockSize * 2
+
+	unpooled bool // Allocated by WithNoPool; Close must not put it in the pool
 }
 
+// hashBufferCap is the capacity of the hash1 and hash2 buffers of a state: a full
+// segment, including the trailing character Sum appends. The buffers never need to
+// grow beyond it, since Write stops appending at part1Chunks.
+const hashBufferCap = spamSumLength
+
+// reset prepares the state for a new input hashed at blockSize, keeping its hash buffers.
+// Buffers grown far beyond hashBufferCap, which only a bug could cause, are replaced so
+// that pooled states do not keep accumulating memory.
 func (state *ssdeepState) reset(blockSize uint32) {
+	if cap(state.hash1) > 2*hashBufferCap || cap(state.hash2) > 2*hashBufferCap {
+		slog.Default().Warn("ssdeep: replacing oversized hash buffers",
+			"cap1", cap(state.hash1), "cap2", cap(state.hash2), "expected", hashBufferCap)
+		state.hash1 = make([]byte, 0, hashBufferCap)
+		state.hash2 = make([]byte, 0, hashBufferCap)
+	}
 	h1, h2 := state.hash1[:0], state.hash2[:0]
 	*state = ssdeepState{
 		blockSize: blockSize,
+		seed:      hashInit,
 		p1:        hashInit,
 		p2:        hashInit,
 		hash1:     h1,
@@ -141,15 +436,49 @@ func newSSDeepState(blockSize uint32) *ssdeepState {
 	return state
 }
 
+// blockSizeFor returns the forced block size if one is set, or the estimate for size bytes
+func (opts *hashOptions) blockSizeFor(size int64) uint32 {
+	if opts.blockSize != 0 {
+		return opts.blockSize
+	}
+	return estimateBlockSize(size)
+}
+
+// validBlockSize reports whether bs is minBlockSize times a power of two
+func validBlockSize(bs uint32) bool {
+	q := bs / minBlockSize
+	return bs%minBlockSize == 0 && q != 0 && q&(q-1) == 0
+}
+
+// newState initializes a new ssdeepState configured by the hash options
+func (opts *hashOptions) newState(blockSize uint32) *ssdeepState {
+	var state *ssdeepState
+	if opts.noPool {
+		state = ssdeepStatePool.New().(*ssdeepState)
+		state.reset(blockSize)
+		state.unpooled = true
+	} else {
+		state = newSSDeepState(blockSize)
+	}
+	if opts.seed != 0 {
+		state.seed, state.p1, state.p2 = opts.seed, opts.seed, opts.seed
+	}
+	return state
+}
+
 // Write processes the input byte stream and updates the hash state.
 // It maintains both rolling hash (for determining chunk boundaries) and piecewise hash (for calculating block content digests).
+//
+// Like the official tool, a segment holds at most part1Chunks or part2Chunks characters
+// of single chunks. Past them the piecewise hash is no longer reset, so the last character
+// Sum adds covers all the remaining data.
 func (state *ssdeepState) Write(p []byte) (n int, err error) {
 	bs1 := state.blockSize
 	bs2 := bs1 * 2
 	h1, h2, h3 := state.h1, state.h2, state.h3
 	p1, p2 := state.p1, state.p2
-	n_idx := state.n
-	winIdx := n_idx % windowSize
+	seed := state.seed
+	winIdx := uint32(state.n % windowSize)
 
 	for _, c := range p {
 		u_c := uint32(c)
@@ -158,19 +487,24 @@ func (state *ssdeepState) Write(p []byte) (n int, err error) {
 		// 	- h1 represents sum of window bytes (maintained by adding new byte and removing oldest byte)
 		// 	- h2 accumulates h1 over time, providing temporal diffusion for boundary triggering
 		// 	- h3 introduces bit mixing through left shift and XOR with new byte for better randomness
-		// Specific update form comes from original implementation, proven in practice to closely match official behavior:
+		// Specific update form comes from original implementation, proven in practice to closely match official behavior.
+		// h2 MUST be updated before h1: it subtracts the sum of the window before the new byte
+		// enters it. Updating h1 first shifts the boundaries and breaks compatibility with the
+		// official tool, as TestRollingHashUpdateOrder checks.
 		h2 -= h1
 		h2 += windowSize * u_c
 
 		h1 += u_c
 		h1 -= uint32(state.window[winIdx])
 
+		// The compiler cannot prove winIdx in range, but the bounds check is always taken the
+		// same way and costs little. Wrapping with a modulo drops it yet runs much slower, and
+		// masking a window padded to 8 bytes drops it with no measurable gain (BenchmarkStateWrite).
 		state.window[winIdx] = c
 		winIdx++
 		if winIdx == windowSize {
 			winIdx = 0
 		}
-		n_idx++
 
 		h3 <<= 5
 		h3 ^= u_c
@@ -186,17 +520,23 @@ func (state *ssdeepState) Write(p []byte) (n int, err error) {
 		// Check if first chunk boundary reached (blockSize)
 		// Optimization: h % bs2 == bs2-1 implies h % bs1 == bs1-1 because bs2 = bs1 * 2
 		if h%bs1 == (bs1 - 1) {
-			if len(state.hash1) < spamSumLength {
+			state.triggers1++
+			if len(state.hash1) < part1Chunks {
 				state.hash1 = append(state.hash1, base64Chars[p1%64])
+				p1 = seed // Reset piecewise hash to process next chunk
+			} else {
+				state.tail1 = base64Chars[p1%64]
 			}
-			p1 = hashInit // Reset piecewise hash to process next chunk
 
 			// Check if second chunk boundary reached (blockSize * 2)
 			if h%bs2 == (bs2 - 1) {
-				if len(state.hash2) < spamSumLength {
+				state.triggers2++
+				if len(state.hash2) < part2Chunks {
 					state.hash2 = append(state.hash2, base64Chars[p2%64])
+					p2 = seed
+				} else {
+					state.tail2 = base64Chars[p2%64]
 				}
-				p2 = hashInit
 			}
 		}
 	}
@@ -204,112 +544,473 @@ func (state *ssdeepState) Write(p []byte) (n int, err error) {
 	// Write local variables back to state struct
 	state.h1, state.h2, state.h3 = h1, h2, h3
 	state.p1, state.p2 = p1, p2
-	state.n = n_idx
+	state.n += uint64(len(p))
 
 	return len(p), nil
 }
 
+// shortDigest reports whether fewer than spamSumLength/2 boundaries added a character
+// to the first segment. The official tool then hashes the input again at half the block
+// size, which WithAdaptiveBlockSize reproduces.
+func (state *ssdeepState) shortDigest() bool {
+	return len(state.hash1) < spamSumLength/2
+}
+
+// bytesWritten returns the total number of bytes processed so far
+func (state *ssdeepState) bytesWritten() int64 {
+	return int64(state.n)
+}
+
 // Sum returns the final generated ssdeep hash string in format "blockSize:hash1:hash2"
 func (state *ssdeepState) Sum() string {
-	// Process remaining data even if no boundary was reached
-	r1 := state.hash1
-	if state.p1 != hashInit && len(r1) < spamSumLength {
+	// Like the official tool, append the piecewise hash of the data since the last
+	// reset whenever the rolling hash is nonzero. Input ending in windowSize zero bytes,
+	// which leaves the rolling hash at zero, gets no trailing character, except that a
+	// segment past its single chunk characters keeps the one of its last boundary.
+	r1, r2 := state.hash1, state.hash2
+	if state.h1+state.h2+state.h3 != 0 {
 		r1 = append(r1, base64Chars[state.p1%64])
-	}
-	r2 := state.hash2
-	if state.p2 != hashInit && len(r2) < spamSumLength {
 		r2 = append(r2, base64Chars[state.p2%64])
+	} else {
+		if state.tail1 != 0 {
+			r1 = append(r1, state.tail1)
+		}
+		if state.tail2 != 0 {
+			r2 = append(r2, state.tail2)
+		}
 	}
 
-	hash := make([]byte, 0, len(r1)+len(r2)+20)
-	hash = strconv.AppendInt(hash, int64(state.blockSize), 10)
+	// Longest hash: 10 digit block size, two full segments, 8 digit seed and 3 separators
+	var buf [10 + 2*spamSumLength + 8 + 3]byte
+	hash := strconv.AppendInt(buf[:0], int64(state.blockSize), 10)
 	hash = append(hash, ':')
 	hash = append(hash, r1...)
 	hash = append(hash, ':')
 	hash = append(hash, r2...)
+	if state.seed != hashInit {
+		hash = append(hash, ':')
+		hash = strconv.AppendUint(hash, uint64(state.seed), 16)
+	}
 	return string(hash)
 }
 
 func (state *ssdeepState) Close() error {
+	if state.unpooled {
+		return nil
+	}
 	ssdeepStatePool.Put(state)
 	return nil
 }
 
 // Compare calculates similarity score (0 to 100) between two ssdeep hash values.
 // Score of 100 means completely identical, 0 means no significant similarity.
+// Hashes whose block sizes are neither equal nor 2x apart score 0 with
+// ErrIncompatibleBlockSizes, telling "cannot compare" apart from "not similar".
+// Identical valid hash strings always score 100; invalid ones fail like any other.
 func Compare(hash1, hash2 string) (int, error) {
-	p1 := strings.Split(hash1, ":")
-	p2 := strings.Split(hash2, ":")
-	if len(p1) != 3 || len(p2) != 3 {
-		return 0, fmt.Errorf("invalid hash format")
+	// Identical hashes need no scoring; this is the common case in deduplication pipelines.
+	// Validating one of them is enough.
+	if hash1 == hash2 {
+		if _, err := Parse(hash1); err != nil {
+			return 0, err
+		}
+		return 100, nil
 	}
 
-	var (
-		b1, b2 int
-		err    error
-	)
+	h1, err := Parse(hash1)
+	if err != nil {
+		return 0, err
+	}
+
+	h2, err := Parse(hash2)
+	if err != nil {
+		return 0, err
+	}
+
+	return h1.Score(h2)
+}
+
+// CompareSegments calculates similarity score (0 to 100) between two hashes given
+// as pre-parsed components. It applies the same rules as Compare without parsing
+// or allocating, which suits callers that store block size and segments separately.
+// Segments longer than spamSumLength are supported up to maxSegmentLength, beyond
+// which ErrSegmentTooLong is returned.
+func CompareSegments(part1a, part2a, part1b, part2b string, blockSizeA, blockSizeB uint32) (int, error) {
+	return compareSegments(part1a, part2a, part1b, part2b, blockSizeA, blockSizeB, &hashOptions{})
+}
+
+// CompareWithOptions calculates similarity score (0 to 100) like Compare, with comparison
+// behavior adjusted by options such as WithHashBothDirections. Hashing options are ignored.
+func CompareWithOptions(hash1, hash2 string, options ...Option) (int, error) {
+	var opts hashOptions
+	for _, o := range options {
+		o.apply(&opts)
+	}
+
+	h1, err := Parse(hash1)
+	if err != nil {
+		return 0, err
+	}
 
-	if b1, err = strconv.Atoi(p1[0]); err != nil {
+	h2, err := Parse(hash2)
+	if err != nil {
 		return 0, err
 	}
 
-	if b2, err = strconv.Atoi(p2[0]); err != nil {
+	if err = h1.comparable(h2); err != nil {
 		return 0, err
 	}
 
-	s1_1, s1_2 := p1[1], p1[2]
-	s2_1, s2_2 := p2[1], p2[2]
+	return compareSegments(h1.Part1, h1.Part2, h2.Part1, h2.Part2, h1.BlockSize, h2.BlockSize, &opts)
+}
+
+// compareSegments implements CompareSegments with the comparison options applied
+func compareSegments(part1a, part2a, part1b, part2b string, blockSizeA, blockSizeB uint32, opts *hashOptions) (int, error) {
+	cfg := opts.scoreConfig()
+
+	if max(len(part1a), len(part2a), len(part1b), len(part2b)) > maxSegmentLength {
+		return 0, ErrSegmentTooLong
+	}
+
+	b1, b2 := uint64(blockSizeA), uint64(blockSizeB)
 
 	// 块大小必须相等，或者成 2 倍关系
 	if b1 != b2 && b1 != b2*2 && b2 != b1*2 {
-		return 0, nil
+		return 0, ErrIncompatibleBlockSizes
 	}
 
 	switch b1 {
 	case b2:
 		// compare equal block size parts
-		score1 := score(s1_1, s2_1, uint32(b1))
-		score2 := score(s1_2, s2_2, uint32(b1*2))
+		score1 := cfg.score(part1a, part1b, blockSizeA)
+		score2 := cfg.score(part2a, part2b, blockSizeA*2)
 
 		// Saturated hash rule: if both first parts are max length (64),
 		// they are potentially truncated. Favor the second part if it matches.
-		if len(s1_1) >= spamSumLength && len(s2_1) >= spamSumLength && score2 > 0 {
+		if saturated(part1a, part1b) && score2 > 0 {
 			return score2, nil
 		}
 
 		return max(score1, score2), nil
 	case b2 * 2:
 		// compare hash1 first part and hash2 second part
-		return score(s1_1, s2_2, uint32(b1)), nil
+		s := cfg.score(part1a, part2b, blockSizeA)
+		if opts.bothDirections {
+			s = max(s, cfg.score(part2a, part1b, blockSizeB))
+		}
+		return s, nil
 	default:
 		// compare hash1 second part and hash2 first part
-		return score(s1_2, s2_1, uint32(b2)), nil
+		s := cfg.score(part2a, part1b, blockSizeB)
+		if opts.bothDirections {
+			s = max(s, cfg.score(part1a, part2b, blockSizeA))
+		}
+		return s, nil
 	}
 }
 
-// score calculates similarity between two hash segment strings using the official ssdeep algorithm:
+// saturated reports whether the first segments of two hashes with the same block size
+// both reached spamSumLength, so that they may have stopped growing before the end of
+// their inputs
+func saturated(part1a, part1b string) bool {
+	return len(part1a) >= spamSumLength && len(part1b) >= spamSumLength
+}
+
+// CompareInfo describes a comparison made by CompareWithInfo beyond its score.
+type CompareInfo struct {
+	// Saturated is set when both hashes have the same block size and both first segments
+	// reached spamSumLength characters. Such segments may have stopped growing before the
+	// end of their inputs, so the score leans on the second segment and may be less reliable.
+	Saturated bool
+}
+
+// CompareWithInfo calculates similarity score (0 to 100) like Compare, and also reports
+// details of the comparison, such as saturated segments, e.g. for services that log a
+// warning about comparisons of very large files. It fails exactly when Compare does.
+func CompareWithInfo(hash1, hash2 string) (int, CompareInfo, error) {
+	s, err := Compare(hash1, hash2)
+	if err != nil {
+		return 0, CompareInfo{}, err
+	}
+
+	// Compare validated both hashes
+	h1, _ := Parse(hash1)
+	h2, _ := Parse(hash2)
+	info := CompareInfo{
+		Saturated: h1.BlockSize == h2.BlockSize && saturated(h1.Part1, h2.Part1),
+	}
+	return s, info, nil
+}
+
+// CompatibleBlockSizes returns the only block sizes a hash with blockSize can be compared
+// against with a nonzero score: half, equal and double. Half is 0 when blockSize is odd,
+// since no block size is half of it. Index and bucketing code can use it to pick which
+// buckets to probe.
+func CompatibleBlockSizes(blockSize int) [3]int {
+	half := 0
+	if blockSize%2 == 0 {
+		half = blockSize / 2
+	}
+	return [3]int{half, blockSize, blockSize * 2}
+}
+
+// CompareWeighted calculates similarity score (0 to 100) like Compare, but scales down
+// matches between hashes whose block sizes differ by 2x to crossBlockSizeWeight percent.
+// Such matches rest on a single segment pairing, so they deserve less confidence than
+// an equal block size match when ranking results. Compare is unaffected.
+func CompareWeighted(hash1, hash2 string) (int, error) {
+	h1, err := Parse(hash1)
+	if err != nil {
+		return 0, err
+	}
+
+	h2, err := Parse(hash2)
+	if err != nil {
+		return 0, err
+	}
+
+	s, err := h1.Score(h2)
+	if h1.BlockSize != h2.BlockSize {
+		s = s * crossBlockSizeWeight / 100
+	}
+
+	return s, err
+}
+
+// EstimateDifference translates a comparison score into an approximate fraction (0 to 1)
+// of the input that differs, for "these files are ~X% different" readouts. Scores are not
+// linear in the amount of change, so this is a heuristic:
+//
+// A changed region replaces the digest characters of the blocks it covers, and each
+// replaced character costs one edit out of the combined length of both segments. The
+// score therefore drops by about 50 points as the changed fraction goes from 0 to 1,
+// giving difference = (100 - score) / 50. Calibrating on 1 MiB random inputs with one
+// contiguous region replaced, averaged over 20 trials, matches it closely:
+//
+//	changed  10%  20%  30%  50%  80%
+//	score     94   90   84   76   61
+//
+// Scores of 50 or less mean the segments no longer line up, and map to 1. Many small
+// edits scattered across the input touch more blocks than their size suggests, so for
+// them the estimate overstates the difference.
+func EstimateDifference(score int) float64 {
+	score = min(max(score, 0), 100)
+	return min(float64(100-score)/50, 1)
+}
+
+// AnyMatch compares query against candidates in input order and stops at the first
+// one scoring at least threshold, returning its index and score. It is cheaper than
+// scoring every candidate when only one match is needed. Candidates that fail to
+// parse or compare are skipped. found is false when no candidate qualifies or the
+// query itself is invalid.
+func AnyMatch(query string, candidates []string, threshold int) (index int, score int, found bool) {
+	q, err := Parse(query)
+	if err != nil {
+		return -1, 0, false
+	}
+
+	for i, candidate := range candidates {
+		s, err := q.ScoreStr(candidate)
+		if err != nil {
+			continue
+		}
+		if s >= threshold {
+			return i, s, true
+		}
+	}
+
+	return -1, 0, false
+}
+
+// CompareResult is a candidate scored by CompareMany.
+type CompareResult struct {
+	Index     int      // Position of the candidate in the candidates slice
+	Match     string   // The candidate hash
+	MatchInfo HashInfo // The candidate hash, parsed
+	Score     int      // Similarity score (0 to 100)
+}
+
+// CompareMany scores target against every candidate and returns those scoring at least
+// minScore, in candidate order. target is parsed once rather than for every comparison.
+// Candidates that fail to parse or cannot be compared with target are skipped.
+// An error is returned only if target is invalid.
+func CompareMany(target string, candidates []string, minScore int) ([]CompareResult, error) {
+	t, err := Parse(target)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []CompareResult
+	for i, candidate := range candidates {
+		c, err := Parse(candidate)
+		if err != nil {
+			continue
+		}
+		s, err := compareParsed(t, c)
+		if err != nil {
+			continue
+		}
+		if s >= minScore {
+			results = append(results, CompareResult{Index: i, Match: candidate, MatchInfo: c, Score: s})
+		}
+	}
+
+	return results, nil
+}
+
+// CompareManyParsed is CompareMany for hashes that are already parsed, e.g. loaded from
+// a store, so no parsing happens at all. Results carry the candidate in MatchInfo and
+// leave Match empty. An error is returned only if a segment of target is longer than
+// maxSegmentLength, which no comparison would accept.
+func CompareManyParsed(target HashInfo, candidates []HashInfo, minScore int) ([]CompareResult, error) {
+	if max(len(target.Part1), len(target.Part2)) > maxSegmentLength {
+		return nil, ErrSegmentTooLong
+	}
+
+	var results []CompareResult
+	for i, candidate := range candidates {
+		s, err := compareParsed(target, candidate)
+		if err != nil {
+			continue
+		}
+		if s >= minScore {
+			results = append(results, CompareResult{Index: i, MatchInfo: candidate, Score: s})
+		}
+	}
+
+	return results, nil
+}
+
+// CompareSlice scores every query against every candidate and returns the matrix of
+// scores, where result[i][j] is the score of queries[i] against candidates[j]. Duplicate
+// hashes are common for templated content, so each distinct hash is parsed once and each
+// distinct pair scored once, then the scores are expanded back to the original indices.
+// Hashes that fail to parse or cannot be compared score 0.
+func CompareSlice(queries, candidates []string) [][]int {
+	return compareSlice(queries, candidates, compareParsed)
+}
+
+// compareSlice implements CompareSlice, scoring distinct pairs with compare
+func compareSlice(queries, candidates []string, compare func(h1, h2 HashInfo) (int, error)) [][]int {
+	qIndex, qUnique := uniqueHashes(queries)
+	cIndex, cUnique := uniqueHashes(candidates)
+
+	unique := make([][]int, len(qUnique))
+	for i, q := range qUnique {
+		unique[i] = make([]int, len(cUnique))
+		if q == nil {
+			continue
+		}
+		for j, c := range cUnique {
+			if c == nil {
+				continue
+			}
+			s, err := compare(*q, *c)
+			if err != nil {
+				continue
+			}
+			unique[i][j] = s
+		}
+	}
+
+	scores := make([][]int, len(queries))
+	for i := range queries {
+		scores[i] = make([]int, len(candidates))
+		for j := range candidates {
+			scores[i][j] = unique[qIndex[i]][cIndex[j]]
+		}
+	}
+	return scores
+}
+
+// uniqueHashes parses the distinct hashes in hashes. It returns the position of each
+// hash in the distinct list, and the list itself with nil for hashes that fail to parse.
+func uniqueHashes(hashes []string) ([]int, []*HashInfo) {
+	var (
+		index  = make([]int, len(hashes))
+		unique []*HashInfo
+		seen   = make(map[string]int)
+	)
+	for i, hash := range hashes {
+		u, ok := seen[hash]
+		if !ok {
+			u = len(unique)
+			seen[hash] = u
+			var info *HashInfo
+			if h, err := Parse(hash); err == nil {
+				info = &h
+			}
+			unique = append(unique, info)
+		}
+		index[i] = u
+	}
+	return index, unique
+}
+
+// scoreConfig holds the tunable constants of the scoring algorithm.
+// defaultScoreConfig reproduces the official ssdeep behavior used by Compare;
+// other values exist for experimenting with custom similarity behavior.
+type scoreConfig struct {
+	shrinkRun int          // Runs of identical characters are shortened to this length
+	damerau   bool         // Measure distance with damerauLevenshtein instead of levenshtein
+	short     ShortPenalty // Score cap for short segments
+}
+
+var defaultScoreConfig = scoreConfig{
+	shrinkRun: 3,
+	short:     ShortPenalty{Length: shortSegmentPenaltyLen, Divisor: shortSegmentPenaltyDivisor},
+}
+
+// scoreConfig returns the scoring configuration selected by the comparison options
+func (opts *hashOptions) scoreConfig() scoreConfig {
+	cfg := defaultScoreConfig
+	cfg.damerau = opts.damerau
+	if opts.shortPenaltySet {
+		cfg.short = opts.shortPenalty
+	}
+	return cfg
+}
+
+// score calculates similarity between two hash segment strings using the official ssdeep algorithm
+func score(s1, s2 string, blockSize uint32) int {
+	return defaultScoreConfig.score(s1, s2, blockSize)
+}
+
+// score calculates similarity between two hash segment strings:
 //  1. Shrink strings
 //  2. Calculate Levenshtein distance
 //  3. Normalize distance to a score 0-100 and apply heuristics
-func score(s1, s2 string, _ uint32) int {
+func (cfg *scoreConfig) score(s1, s2 string, _ uint32) int {
+	// An empty segment holds no digest to match, not even another empty one.
+	// Identical hashes still score 100 through Compare's fast path.
+	if len(s1) == 0 || len(s2) == 0 {
+		return 0
+	}
 	if s1 == s2 {
 		return 100
 	}
 
-	// Use stack-allocated buffers for shrinking to avoid allocations
+	// Use stack-allocated buffers for shrinking to avoid allocations.
+	// Longer segments from other CTPH variants grow them on the heap via append.
 	var b1Buf, b2Buf [spamSumLength]byte
-	b1 := shrink(s1, b1Buf[:0])
-	b2 := shrink(s2, b2Buf[:0])
+	b1 := shrinkSWAR(s1, cfg.shrinkRun, b1Buf[:0])
+	b2 := shrinkSWAR(s2, cfg.shrinkRun, b2Buf[:0])
 
 	n1 := len(b1)
 	n2 := len(b2)
 
-	// Official check: strings must have a minimum length
-	if n1 < windowSize || n2 < windowSize {
+	// Official check: strings must have a minimum length (see minHashSegmentLen)
+	if n1 < minHashSegmentLen || n2 < minHashSegmentLen {
 		return 0
 	}
 
-	dist := levenshtein(b1, b2)
+	// Called directly rather than through a func field, which would move the buffers to the heap
+	var dist int
+	if cfg.damerau {
+		dist = damerauLevenshtein(b1, b2)
+	} else {
+		dist = levenshtein(b1, b2)
+	}
 
 	// Official ssdeep formula
 	s := uint32(dist) * spamSumLength / uint32(n1+n2)
@@ -317,9 +1018,9 @@ func score(s1, s2 string, _ uint32) int {
 	dist = 100 - int(s)
 
 	// Short string penalty
-	// This matches the official heuristic for strings shorter than 11 chars
-	if n1 < 11 || n2 < 11 {
-		limit := int(uint32(min(n1, n2)) * 100 / 14)
+	// By default this matches the official heuristic for strings shorter than shortSegmentPenaltyLen chars
+	if (n1 < cfg.short.Length || n2 < cfg.short.Length) && cfg.short.Divisor > 0 {
+		limit := min(n1, n2) * 100 / cfg.short.Divisor
 		if dist > limit {
 			dist = limit
 		}
@@ -342,8 +1043,16 @@ func levenshtein(s1, s2 []byte) int {
 		return n1
 	}
 
-	// Use two rows to save space
-	row := make([]int, n2+1)
+	// Use two rows to save space, on the stack for segments up to spamSumLength
+	var (
+		rowBuf [spamSumLength + 1]int
+		row    []int
+	)
+	if n2 < len(rowBuf) {
+		row = rowBuf[:n2+1]
+	} else {
+		row = make([]int, n2+1)
+	}
 	for j := 0; j <= n2; j++ {
 		row[j] = j
 	}
@@ -365,12 +1074,66 @@ func levenshtein(s1, s2 []byte) int {
 	return row[n2]
 }
 
-// shrink compresses characters that repeat consecutively more than 3 times, which is part of ssdeep similarity algorithm
-func shrink(s string, buf []byte) []byte {
-	n := len(s)
-	for i := range n {
+// damerauLevenshtein computes the optimal string alignment distance: the Levenshtein
+// distance where swapping two adjacent characters also costs a single edit. It keeps
+// three rows of the distance matrix, so it needs O(len(s2)) extra space.
+func damerauLevenshtein(s1, s2 []byte) int {
+	n1 := len(s1)
+	n2 := len(s2)
+	if n1 == 0 {
+		return n2
+	}
+	if n2 == 0 {
+		return n1
+	}
+
+	// Rows i-2, i-1 and i, on the stack for segments up to spamSumLength
+	var (
+		rowBuf           [3 * (spamSumLength + 1)]int
+		prev2, prev, cur []int
+	)
+	if n2 < spamSumLength+1 {
+		prev2, prev, cur = rowBuf[:n2+1], rowBuf[spamSumLength+1:][:n2+1], rowBuf[2*(spamSumLength+1):][:n2+1]
+	} else {
+		prev2, prev, cur = make([]int, n2+1), make([]int, n2+1), make([]int, n2+1)
+	}
+	for j := 0; j <= n2; j++ {
+		prev[j] = j
+	}
+
+	for i := 1; i <= n1; i++ {
+		cur[0] = i
+		for j := 1; j <= n2; j++ {
+			cost := 1
+			if s1[i-1] == s2[j-1] {
+				cost = 0
+			}
+			val := min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && s1[i-1] == s2[j-2] && s1[i-2] == s2[j-1] {
+				val = min(val, prev2[j-2]+1)
+			}
+			cur[j] = val
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+
+	return prev[n2]
+}
+
+// shrink compresses characters that repeat consecutively more than run times (3 in the official
+// algorithm) down to run characters, which is part of ssdeep similarity algorithm.
+// score uses the faster shrinkSWAR; this is the reference it is tested against.
+func shrink(s string, run int, buf []byte) []byte {
+	count := 0
+	for i := range len(s) {
 		c := s[i]
-		if i < 3 || c != s[i-1] || c != s[i-2] || c != s[i-3] {
+		if i > 0 && c == s[i-1] {
+			count++
+		} else {
+			count = 1
+		}
+
+		if count <= run {
 			buf = append(buf, c)
 		}
 	}
@@ -378,39 +1141,283 @@ func shrink(s string, buf []byte) []byte {
 	return buf
 }
 
-// sumWithFixedSize processes data stream with a fixed size, using the correct block size
-func sumWithFixedSize(r io.Reader, fixedSize int64) (string, error) {
+// shrinkSWAR is shrink working on 8 bytes at a time (SIMD Within A Register). Digest
+// characters rarely repeat, so most words hold no two equal adjacent bytes and can be
+// copied whole; words that do are shrunk byte by byte, as is the tail.
+func shrinkSWAR(s string, run int, buf []byte) []byte {
+	const (
+		lows  = 0x0101010101010101
+		highs = 0x8080808080808080
+	)
+
+	if len(s) == 0 {
+		return buf
+	}
+	buf = append(buf, s[0])
+	count := 1
+
+	i := 1
+	for ; i+8 <= len(s); i += 8 {
+		// Compare each byte with its predecessor: a zero byte in x marks a repeat
+		w := binary.LittleEndian.Uint64([]byte(s[i : i+8]))
+		prev := binary.LittleEndian.Uint64([]byte(s[i-1 : i+7]))
+		x := w ^ prev
+		if (x-lows)&^x&highs == 0 {
+			buf = append(buf, s[i:i+8]...)
+			count = 1
+			continue
+		}
+		buf, count = shrinkBytes(s, i, i+8, run, count, buf)
+	}
+
+	buf, _ = shrinkBytes(s, i, len(s), run, count, buf)
+	return buf
+}
+
+// shrinkBytes runs shrink over s[from:to] for shrinkSWAR, given the length count of the
+// run ending at s[from-1]. It returns buf and the length of the run ending at s[to-1].
+func shrinkBytes(s string, from, to, run, count int, buf []byte) ([]byte, int) {
+	for i := from; i < to; i++ {
+		if s[i] == s[i-1] {
+			count++
+		} else {
+			count = 1
+		}
+		if count <= run {
+			buf = append(buf, s[i])
+		}
+	}
+	return buf, count
+}
+
+// sumWithFixedSize processes data stream with a fixed size, using the correct block size.
+// It also reports whether the first segment of the hash is short (see shortDigest).
+func sumWithFixedSize(r io.Reader, fixedSize int64, opts *hashOptions) (hash string, short bool, err error) {
 	if fixedSize <= 0 {
-		return "", ErrEmptyData
+		return "", false, ErrEmptyData
 	}
 
 	// Use the known size to set the correct block size
-	blockSize := estimateBlockSize(fixedSize)
-	state := newSSDeepState(blockSize)
+	blockSize := opts.blockSizeFor(fixedSize)
+	state := opts.newState(blockSize)
+	defer state.Close()
+
+	if _, err = io.Copy(state, r); err != nil {
+		return "", false, err
+	}
+
+	if opts.verifySize && state.bytesWritten() != fixedSize {
+		return "", false, fmt.Errorf("%w: expected %d bytes, read %d", ErrSizeMismatch, fixedSize, state.bytesWritten())
+	}
+
+	return state.Sum(), state.shortDigest(), nil
+}
+
+// DebugInfo describes how a hash was produced, to help understand why an input
+// yielded a short hash or an unexpected block size.
+type DebugInfo struct {
+	BlockSize  uint32 // Block size chosen for the input
+	Part1Len   int    // Length of the first hash segment
+	Part2Len   int    // Length of the second hash segment
+	TotalBytes int64  // Number of bytes hashed
+	Triggers1  uint64 // Boundaries hit at BlockSize
+	Triggers2  uint64 // Boundaries hit at BlockSize * 2
+}
+
+// Debug computes the ssdeep fuzzy hash for data like Bytes and reports diagnostic details.
+// Boundaries are counted over all of data, including those past the end of full segments.
+func Debug(data []byte) (string, DebugInfo, error) {
+	if len(data) == 0 {
+		return "", DebugInfo{}, ErrEmptyData
+	}
+
+	state := newSSDeepState(estimateBlockSize(int64(len(data))))
 	defer state.Close()
+	state.Write(data)
 
-	_, err := io.Copy(state, r)
+	hash := state.Sum()
+	h, err := Parse(hash)
 	if err != nil {
-		return "", err
+		return "", DebugInfo{}, err
 	}
 
-	return state.Sum(), nil
+	return hash, DebugInfo{
+		BlockSize:  state.blockSize,
+		Part1Len:   len(h.Part1),
+		Part2Len:   len(h.Part2),
+		TotalBytes: state.bytesWritten(),
+		Triggers1:  state.triggers1,
+		Triggers2:  state.triggers2,
+	}, nil
+}
+
+// Stats reports the decisions made while hashing, for monitoring and tuning hashing
+// in production services (see SumWithStats).
+type Stats struct {
+	BytesProcessed int64  // Bytes hashed over all passes
+	BlockSize      uint32 // Block size of the hash
+	Halvings       int    // Times the block size was halved by WithAdaptiveBlockSize
+	Part1Len       int    // Length of the first hash segment
+	Part2Len       int    // Length of the second hash segment
+}
+
+// SumWithStats computes the ssdeep fuzzy hash of data like SumBytes, and reports how it
+// was computed. Of the options, WithBlockSize, WithAdaptiveBlockSize, WithHashSeed and
+// WithNoPool apply. Like SumBytes, empty data yields "3::". It only fails with
+// ErrInvalidBlockSize, for a block size that Bytes rejects too.
+func SumWithStats(data []byte, options ...Option) (string, Stats, error) {
+	opts := streamOptions(options)
+	if opts.blockSize != 0 && !validBlockSize(opts.blockSize) {
+		return "", Stats{}, fmt.Errorf("%w: %d", ErrInvalidBlockSize, opts.blockSize)
+	}
+
+	var stats Stats
+	sum := func(blockSize uint32) (string, bool, error) {
+		state := opts.newState(blockSize)
+		defer state.Close()
+		state.Write(data)
+		stats.BytesProcessed += state.bytesWritten()
+		return state.Sum(), state.shortDigest(), nil
+	}
+
+	// Neither sum nor parsing a hash it computed can fail
+	hash, short, _ := sum(opts.blockSizeFor(int64(len(data))))
+	hash, _ = opts.adapt(hash, short, func(blockSize uint32) (string, bool, error) {
+		stats.Halvings++
+		return sum(blockSize)
+	})
+	h, _ := Parse(hash)
+
+	stats.BlockSize, stats.Part1Len, stats.Part2Len = h.BlockSize, len(h.Part1), len(h.Part2)
+	return hash, stats, nil
 }
 
 // Bytes computes the ssdeep fuzzy hash for a given byte slice.
-func Bytes(data []byte) (string, error) {
-	return sumWithFixedSize(bytes.NewReader(data), int64(len(data)))
+// Options apply as for Stream, e.g. WithBlockSize or WithHashSeed, except that
+// WithFixedSize is ignored since the size of data is known.
+func Bytes(data []byte, options ...Option) (string, error) {
+	if len(options) == 0 {
+		// Without options nothing needs to escape to the heap
+		hash, _, err := sumWithFixedSize(bytes.NewReader(data), int64(len(data)), &hashOptions{})
+		return hash, err
+	}
+
+	opts := streamOptions(options)
+	opts.size = int64(len(data))
+	return opts.stream(bytes.NewReader(data))
+}
+
+// SumBytes computes the ssdeep fuzzy hash of data with a single Write, without
+// wrapping it in a reader, e.g. for data in a memory-mapped region. Unlike Bytes it
+// cannot fail: empty data yields the hash of no input, "3::".
+func SumBytes(data []byte) string {
+	state := newSSDeepState(estimateBlockSize(int64(len(data))))
+	defer state.Close()
+
+	state.Write(data)
+	return state.Sum()
 }
 
 // File computes the ssdeep fuzzy hash for a file at the given path.
-func File(path string) (string, error) {
-	file, err := os.Open(path)
+// Options apply as for Stream: WithFixedSize overrides the size reported by Stat,
+// and WithBlockSize bypasses the block size estimate. WithNoAtime also applies.
+func File(path string, options ...Option) (string, error) {
+	file, err := openFile(path, options)
 	if err != nil {
 		return "", err
 	}
 	defer file.Close()
 
-	return Stream(file)
+	return streamFile(file, options)
+}
+
+// statFile is (*os.File).Stat, replaceable in tests to count calls
+var statFile = (*os.File).Stat
+
+// streamFile hashes an opened file like Stream. Stat is called only once, and not
+// at all when the size is given with WithFixedSize. Holes in sparse regular files
+// are hashed as the zeros they read as, without reading them.
+func streamFile(file *os.File, options []Option) (string, error) {
+	opts := streamOptions(options)
+	var r io.Reader = file
+	if opts.size <= 0 {
+		info, err := statFile(file)
+		if err != nil {
+			return "", err
+		}
+		opts.useFileInfo(info)
+		if opts.verifySize {
+			r = sparseFileReader(file, info)
+		}
+	}
+
+	return opts.stream(r)
+}
+
+// openFile opens path for reading, honoring the file related options
+func openFile(path string, options []Option) (*os.File, error) {
+	var opts hashOptions
+	for _, o := range options {
+		o.apply(&opts)
+	}
+
+	if opts.noAtime {
+		return openNoAtime(path)
+	}
+	return os.Open(path)
+}
+
+// FileContext computes the ssdeep fuzzy hash for a file at the given path, aborting
+// with the context error once ctx is done. The file is closed on cancellation so
+// that a read blocked on a stalled device is interrupted where the platform allows it.
+func FileContext(ctx context.Context, path string, options ...Option) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	file, err := openFile(path, options)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	stop := context.AfterFunc(ctx, func() { file.Close() })
+	defer stop()
+
+	hash, err := streamFile(file, append(options, contextOption{ctx}))
+	if ctxErr := ctx.Err(); err != nil && ctxErr != nil {
+		return "", ctxErr
+	}
+
+	return hash, err
+}
+
+// StreamContext computes the ssdeep fuzzy hash from an io.Reader like Stream,
+// checking ctx before every read and returning its error once it is done.
+func StreamContext(ctx context.Context, r io.Reader, options ...Option) (string, error) {
+	return Stream(r, append(options, contextOption{ctx})...)
+}
+
+type contextOption struct {
+	ctx context.Context
+}
+
+func (o contextOption) apply(h *hashOptions) {
+	h.ctx = o.ctx
+}
+
+// contextReader stops reading from r once ctx is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+// Read implements io.Reader interface
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
 }
 
 type statReader interface {
@@ -420,41 +1427,121 @@ type statReader interface {
 
 // Stream computes the ssdeep fuzzy hash from an io.Reader.
 // For objects implementing io.ReadSeeker (like files), it pre-fetches the size for optimal block size.
+// Non-regular files such as pipes and devices are treated as streams of unknown size.
+// If the data read from a regular file does not match its Stat size, ErrSizeMismatch is returned.
 // For regular Readers, it tries to determine the size when possible, or estimates block size from initial data.
+// Streams too large for the memory cache spill to a temporary file, which on Linux is preallocated
+// when r reports its remaining length with a Len method, like bytes.Buffer.
 func Stream(r io.Reader, options ...Option) (string, error) {
-	var opts = hashOptions{size: -1, cachedSize: defaultCachedSize}
-	for _, o := range options {
-		o.apply(&opts)
+	// Without options a plain seeker, such as a bytes.Reader, is measured and hashed
+	// in place exactly like Bytes, without building options that escape to the heap
+	if _, ok := r.(statReader); !ok && len(options) == 0 {
+		if rs, ok := r.(io.ReadSeeker); ok {
+			size, err := seekerSize(rs)
+			if err != nil {
+				return "", err
+			}
+			hash, _, err := sumWithFixedSize(rs, size, &hashOptions{})
+			return hash, err
+		}
 	}
 
+	opts := streamOptions(options)
+
 	if opts.size <= 0 {
 		if ri, ok := r.(statReader); ok {
 			info, err := ri.Stat()
 			if err != nil {
 				return "", err
 			}
-
-			opts.size = info.Size()
+			opts.useFileInfo(info)
 		} else if rs, ok := r.(io.ReadSeeker); ok {
-			size, err := rs.Seek(0, io.SeekEnd)
+			size, err := seekerSize(rs)
 			if err != nil {
 				return "", err
 			}
 
-			if _, err = rs.Seek(0, io.SeekStart); err != nil {
-				return "", err
-			}
-
 			opts.size = size
 		}
 	}
 
+	return opts.stream(r)
+}
+
+// streamOptions returns the hash options for Stream, where a negative size means unknown
+func streamOptions(options []Option) hashOptions {
+	var opts = hashOptions{size: -1, cachedSize: defaultCachedSize}
+	for _, o := range options {
+		o.apply(&opts)
+	}
+	return opts
+}
+
+// useFileInfo takes the size to hash from the Stat result of a file.
+// FIFOs, devices and sockets report a meaningless size, so they keep
+// an unknown size and are cached like any non-seekable stream.
+func (opts *hashOptions) useFileInfo(info os.FileInfo) {
+	if info.Mode().IsRegular() {
+		opts.size = info.Size()
+		opts.verifySize = true
+	}
+}
+
+// stream hashes r once its size has been determined, or caches it first if it is unknown
+func (opts *hashOptions) stream(r io.Reader) (string, error) {
+	if opts.blockSize != 0 && !validBlockSize(opts.blockSize) {
+		return "", fmt.Errorf("%w: %d", ErrInvalidBlockSize, opts.blockSize)
+	}
+
+	// Readers such as bytes.Buffer report their remaining length, which is only a
+	// hint: the stream is still read to the end to determine the block size
+	var sizeHint int64
+	if l, ok := r.(interface{ Len() int }); ok {
+		sizeHint = int64(l.Len())
+	}
+
+	// Remember where a seekable input starts, to hash it again in adaptive mode
+	var rewind func() error
+	if rs, ok := r.(io.ReadSeeker); ok && opts.adaptive && opts.size >= 0 {
+		start, err := rs.Seek(0, io.SeekCurrent)
+		if err == nil {
+			rewind = func() error {
+				_, err := rs.Seek(start, io.SeekStart)
+				return err
+			}
+		}
+	}
+
+	if opts.ctx != nil {
+		r = &contextReader{ctx: opts.ctx, r: r}
+	}
+
 	if opts.size >= 0 {
-		return sumWithFixedSize(r, opts.size)
+		hash, short, err := sumWithFixedSize(r, opts.size, opts)
+		if err != nil || rewind == nil {
+			return hash, err
+		}
+		return opts.adapt(hash, short, func(blockSize uint32) (string, bool, error) {
+			if err := rewind(); err != nil {
+				return "", false, err
+			}
+			retry := *opts
+			retry.blockSize = blockSize
+			return sumWithFixedSize(r, opts.size, &retry)
+		})
+	}
+
+	if opts.singlePass {
+		return opts.sumSinglePass(r)
 	}
 
 	// For non-seekable readers, cache the data to determine the correct block size
-	sr := newStreamReader(r, opts.cachedSize, opts.cleanup)
+	sr := newStreamReader(r, opts.cachedSize, opts.cleanup && opts.keepSpill == nil)
+	sr.keepSpill = opts.keepSpill
+	sr.sizeHint = sizeHint
+	if opts.strictSize {
+		sr.cachedSize = opts.cachedSize
+	}
 	defer sr.Close()
 
 	// Read all data to determine total size
@@ -468,8 +1555,8 @@ func Stream(r io.Reader, options ...Option) (string, error) {
 	}
 
 	// Calculate block size based on actual size
-	blockSize := estimateBlockSize(sr.Size())
-	state := newSSDeepState(blockSize)
+	blockSize := opts.blockSizeFor(sr.Size())
+	state := opts.newState(blockSize)
 	defer state.Close()
 
 	// Hash the cached data
@@ -477,7 +1564,115 @@ func Stream(r io.Reader, options ...Option) (string, error) {
 		return "", err
 	}
 
-	return state.Sum(), nil
+	return opts.adapt(state.Sum(), state.shortDigest(), func(blockSize uint32) (string, bool, error) {
+		if err := sr.Reset(); err != nil {
+			return "", false, err
+		}
+		state := opts.newState(blockSize)
+		defer state.Close()
+		if _, err := io.Copy(state, sr); err != nil {
+			return "", false, err
+		}
+		return state.Sum(), state.shortDigest(), nil
+	})
+}
+
+// adapt implements WithAdaptiveBlockSize: while short reports that the first segment
+// of hash is short, it is replaced with the hash computed by rehash at half the block
+// size, until the block size reaches minBlockSize
+func (opts *hashOptions) adapt(hash string, short bool, rehash func(blockSize uint32) (string, bool, error)) (string, error) {
+	if !opts.adaptive || opts.blockSize != 0 {
+		return hash, nil
+	}
+	for short {
+		h, err := Parse(hash)
+		if err != nil {
+			return "", err
+		}
+		if h.BlockSize <= minBlockSize {
+			break
+		}
+		if hash, short, err = rehash(h.BlockSize / 2); err != nil {
+			return "", err
+		}
+	}
+	return hash, nil
+}
+
+// seekerSize returns the number of bytes remaining in rs, leaving its position unchanged.
+// Seekers that cannot seek to the end are measured by reading them through once, which
+// makes hashing them a two-pass operation instead of spilling their data to a cache.
+func seekerSize(rs io.ReadSeeker) (int64, error) {
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+
+	end, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		n, err := io.Copy(io.Discard, rs)
+		if err != nil {
+			return 0, err
+		}
+		end = start + n
+	}
+
+	if _, err = rs.Seek(start, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	return end - start, nil
+}
+
+// CompareStreams hashes two readers and returns their similarity score (0 to 100).
+// Positive sizes select the fixed-size fast path; otherwise each reader is hashed
+// like Stream, detecting its size or caching it as needed.
+func CompareStreams(a, b io.Reader, sizeA, sizeB int64) (int, error) {
+	hashA, err := Stream(a, WithFixedSize(sizeA))
+	if err != nil {
+		return 0, err
+	}
+
+	hashB, err := Stream(b, WithFixedSize(sizeB))
+	if err != nil {
+		return 0, err
+	}
+
+	return Compare(hashA, hashB)
+}
+
+// MatchReader hashes r once and finds the known hash it is most similar to, e.g. to
+// screen uploads against a list of known files as they arrive. A positive size selects
+// the fixed-size fast path, otherwise r is hashed like Stream. Known hashes are scored
+// in order and the search stops at the first one scoring at least threshold; otherwise
+// the best scoring one is returned, the first on ties. Known hashes that fail to parse
+// or compare are skipped. bestIdx is -1 when no known hash scores above 0 or threshold.
+// An error is returned only if r cannot be hashed.
+func MatchReader(r io.Reader, size int64, known []string, threshold int) (bestIdx int, bestScore int, err error) {
+	hash, err := Stream(r, WithFixedSize(size))
+	if err != nil {
+		return -1, 0, err
+	}
+	h, err := Parse(hash)
+	if err != nil {
+		return -1, 0, err
+	}
+
+	bestIdx = -1
+	for i, candidate := range known {
+		s, err := h.ScoreStr(candidate)
+		if err != nil {
+			continue
+		}
+		if s >= threshold {
+			return i, s, nil
+		}
+		if s > bestScore {
+			bestIdx, bestScore = i, s
+		}
+	}
+
+	return bestIdx, bestScore, nil
 }
 
 // estimateBlockSize estimates the initial block size based on total data size, aiming to make the resulting hash length approach 64 characters.
@@ -493,7 +1688,8 @@ func estimateBlockSize(size int64) uint32 {
 }
 
 // streamReader caches stream data in memory (if small) or temporary file (if large)
-// to enable accurate block size calculation for non-seekable streams
+// to enable accurate block size calculation for non-seekable streams.
+// Seekable sources are read in place and rewound instead.
 type streamReader struct {
 	r          io.Reader
 	cached     []byte   // In-memory cache for small streams
@@ -502,6 +1698,13 @@ type streamReader struct {
 	size       int64    // Total size of cached data
 	offset     int64    // Current read position
 	cleanup    bool     // Whether to cleanup temporary resources
+	named      bool     // Whether the temporary file has a directory entry to remove
+	keepSpill  *string  // If set, keep the temporary file and report its path here
+	sizeHint   int64    // Expected stream length if known, to preallocate the temporary file
+	loaded     bool     // Whether ReadAll has completed, so the source is fully cached or measured
+
+	seeker io.ReadSeeker // Seekable source read in place instead of cached
+	start  int64         // Position of seeker when the stream reader was created
 }
 
 // newStreamReader creates a new stream reader with the specified cache size
@@ -510,17 +1713,40 @@ func newStreamReader(r io.Reader, cachedSize int64, cleanup bool) *streamReader
 		cachedSize = minCachedSize
 	}
 
-	return &streamReader{
+	sr := &streamReader{
 		r:          r,
 		cachedSize: cachedSize,
 		cleanup:    cleanup,
 	}
+	// A seekable source can simply be rewound, so it never needs a cache
+	if rs, ok := r.(io.ReadSeeker); ok {
+		sr.seeker = rs
+	}
+	return sr
 }
 
-// ReadAll reads all data from the source stream into cache (memory or file)
+// ReadAll reads all data from the source stream into cache (memory or file).
+// Once it succeeds, further calls do nothing.
 func (sr *streamReader) ReadAll() error {
+	if sr.loaded {
+		return nil
+	}
+	if sr.seeker != nil {
+		// Pipes and devices implement Seek but fail it; those are cached like any stream
+		if start, err := sr.seeker.Seek(0, io.SeekCurrent); err == nil {
+			size, err := seekerSize(sr.seeker)
+			if err != nil {
+				return err
+			}
+			sr.start, sr.size = start, size
+			sr.loaded = true
+			return nil
+		}
+		sr.seeker = nil
+	}
+
 	// Start with memory buffer
-	sr.cached = make([]byte, 0, minCachedSize)
+	sr.cached = make([]byte, 0, min(minCachedSize, sr.cachedSize))
 	buf := make([]byte, 32*1024) // 32KB read buffer
 
 	for {
@@ -548,6 +1774,7 @@ func (sr *streamReader) ReadAll() error {
 
 		if err != nil {
 			if errors.Is(err, io.EOF) {
+				sr.loaded = true
 				return nil
 			}
 			return err
@@ -555,31 +1782,88 @@ func (sr *streamReader) ReadAll() error {
 	}
 }
 
+// Peek returns a copy of the first n bytes of the stream, or all of it if shorter,
+// without moving the read position, so that the format of the data can be checked
+// before hashing it. The stream is cached by ReadAll first if it was not yet; the
+// bytes returned are those that reading from the start after Reset yields.
+func (sr *streamReader) Peek(n int) ([]byte, error) {
+	if err := sr.ReadAll(); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, min(int64(max(n, 0)), sr.size))
+	switch {
+	case sr.seeker != nil:
+		pos, err := sr.seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := sr.seeker.Seek(sr.start, io.SeekStart); err != nil {
+			return nil, err
+		}
+		m, err := io.ReadFull(sr.seeker, buf)
+		if _, seekErr := sr.seeker.Seek(pos, io.SeekStart); seekErr != nil {
+			return nil, seekErr
+		}
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, err
+		}
+		return buf[:m], nil
+	case sr.file != nil:
+		// ReadAt leaves the file offset alone
+		m, err := sr.file.ReadAt(buf, 0)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		return buf[:m], nil
+	default:
+		return buf[:copy(buf, sr.cached)], nil
+	}
+}
+
 // switchToFile migrates cached memory data to a temporary file
 func (sr *streamReader) switchToFile() error {
-	file, err := os.CreateTemp("", "ssdeep-*")
+	var (
+		file  *os.File
+		named = true
+		err   error
+	)
+	if sr.keepSpill != nil {
+		// A kept spill file needs a name to be found after Close
+		file, err = os.CreateTemp("", "ssdeep-*")
+	} else {
+		file, named, err = createTempFile()
+	}
 	if err != nil {
 		return err
 	}
-	sr.file = file
+
+	preallocate(file, sr.sizeHint)
 
 	// Write existing cached data to file
 	if len(sr.cached) > 0 {
-		if _, err := sr.file.Write(sr.cached); err != nil {
-			sr.file.Close()
-			os.Remove(sr.file.Name())
+		if _, err := file.Write(sr.cached); err != nil {
+			file.Close()
+			if named {
+				os.Remove(file.Name())
+			}
 			return err
 		}
 		// Clear memory cache to free memory
 		sr.cached = nil
 	}
 
+	sr.file, sr.named = file, named
 	return nil
 }
 
 // Reset resets the read position to the beginning
 func (sr *streamReader) Reset() error {
 	sr.offset = 0
+	if sr.seeker != nil {
+		_, err := sr.seeker.Seek(sr.start, io.SeekStart)
+		return err
+	}
 	if sr.file != nil {
 		_, err := sr.file.Seek(0, io.SeekStart)
 		return err
@@ -588,7 +1872,19 @@ func (sr *streamReader) Reset() error {
 }
 
 // Read implements io.Reader interface
+// A zero-length p returns 0, nil without touching the cache. Otherwise Read returns
+// at most len(p) bytes and reports io.EOF only once all cached data has been consumed.
 func (sr *streamReader) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if sr.seeker != nil {
+		n, err = sr.seeker.Read(p)
+		sr.offset += int64(n)
+		return n, err
+	}
+
 	if sr.file != nil {
 		n, err = sr.file.Read(p)
 		sr.offset += int64(n)
@@ -611,20 +1907,27 @@ func (sr *streamReader) Size() int64 {
 }
 
 // Close cleans up resources (removes temporary file if created)
+// A seekable source is left open; it belongs to the caller.
 func (sr *streamReader) Close() error {
+	if sr.keepSpill != nil {
+		*sr.keepSpill = ""
+		if sr.file != nil {
+			*sr.keepSpill = sr.file.Name()
+			sr.file.Close()
+			sr.file = nil
+		}
+	}
+
 	if sr.file != nil {
 		if sr.cleanup {
-			fd := int(sr.file.Fd())
-			// sync unwritten dirty pages
-			syscall.Fdatasync(fd)
-
-			// clear page cache
-			unix.Fadvise(fd, 0, 0, unix.FADV_DONTNEED)
+			evictCache(sr.file)
 		}
 
 		name := sr.file.Name()
 		sr.file.Close()
-		os.Remove(name)
+		if sr.named {
+			os.Remove(name)
+		}
 	}
 
 	sr.cached = nil
@@ -1,8 +1,17 @@
 package ssdeep
 
 import (
+	"bytes"
 	"crypto/rand"
+	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -20,6 +29,96 @@ func TestHashBytes(t *testing.T) {
 	}
 }
 
+// tinyInputs are inputs at the minBlockSize floor, where few or no boundaries fire and
+// the trailing characters added by Sum make up much of the hash. The expected hashes were
+// computed by this package, following the trailing character rule of fuzzy_digest in
+// ssdeep 2.14; TestCompatibilityWithOfficialBinary checks them against the official binary.
+var tinyInputs = []struct {
+	name string
+	data []byte
+	hash string
+}{
+	{"1 byte", []byte("a"), "3:E:E"},
+	{"7 bytes", []byte("abcdefg"), "3:u+n:u+n"},
+	{"64 bytes ending on a boundary", sequence(64), "3:Iq103+54vmkCNMvWRQzan:Iq103+54vmkCNMvWRQzan"},
+	// A rolling hash of zero, after windowSize zero bytes, adds no trailing character
+	{"1 zero byte", []byte{0}, "3::"},
+	{"7 zero bytes", make([]byte, 7), "3::"},
+	{"64 zero bytes", make([]byte, 64), "3::"},
+	{"text ending in zero bytes", append([]byte("The quick brown fox jumps over the lazy dog"), make([]byte, 7)...), "3:FJKKIUKacXltl:FHIGyt"},
+}
+
+// sequence returns the bytes 0, 1, ..., n-1.
+func sequence(n int) []byte {
+	seq := make([]byte, n)
+	for i := range seq {
+		seq[i] = byte(i)
+	}
+	return seq
+}
+
+// TestTinyInputs pins the hashes of tinyInputs, in both the default and single pass modes.
+func TestTinyInputs(t *testing.T) {
+	for _, tc := range tinyInputs {
+		hash, err := Bytes(tc.data)
+		require.NoError(t, err)
+		require.Equal(t, tc.hash, hash, tc.name)
+
+		hash, err = Stream(io.MultiReader(bytes.NewReader(tc.data)), WithSinglePass())
+		require.NoError(t, err)
+		require.Equal(t, tc.hash, hash, "%s, single pass", tc.name)
+	}
+}
+
+// TestRollingHashUpdateOrder pins the hash of 64 'A's, computed by this package with h2
+// updated before h1 as in roll_hash of ssdeep 2.14. A run of one byte keeps h1 changing for
+// a whole window, so updating h1 first in Write moves the boundaries and changes the hash.
+func TestRollingHashUpdateOrder(t *testing.T) {
+	data := bytes.Repeat([]byte("A"), 64)
+	const want = "3:Wttkkkkkkkkkkkkkkkkkkkkkkkkkkkkkkkkkkkkkkkkkkkkkkkkkkkkkkkkkn:Yu"
+
+	hash, err := Bytes(data)
+	require.NoError(t, err)
+	require.Equal(t, want, hash)
+
+	hash, err = Stream(io.MultiReader(bytes.NewReader(data)), WithSinglePass())
+	require.NoError(t, err)
+	require.Equal(t, want, hash, "single pass")
+}
+
+func TestBytesOptions(t *testing.T) {
+	data, err := os.ReadFile("testdata/sample2.txt")
+	require.NoError(t, err)
+
+	for _, options := range [][]Option{
+		{WithBlockSize(48)},
+		{WithHashSeed(0xbeef)},
+		{WithNoPool()},
+	} {
+		want, err := Stream(bytes.NewReader(data), options...)
+		require.NoError(t, err)
+		got, err := Bytes(data, options...)
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	}
+
+	hash, err := Bytes(data, WithBlockSize(48))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(hash, "48:"), hash)
+
+	// The size of data wins over WithFixedSize
+	want, err := Bytes(data)
+	require.NoError(t, err)
+	got, err := Bytes(data, WithFixedSize(10))
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+
+	_, err = Bytes(data, WithBlockSize(10))
+	require.ErrorIs(t, err, ErrInvalidBlockSize)
+	_, err = Bytes(nil, WithHashSeed(1))
+	require.ErrorIs(t, err, ErrEmptyData)
+}
+
 func TestCompare(t *testing.T) {
 	s1 := "The quick brown fox jumps over the lazy dog"
 	s2 := "The quick brown fox jumps over the lazy dog!"
@@ -54,18 +153,30 @@ func TestCompare(t *testing.T) {
 }
 
 func TestEmpty(t *testing.T) {
-	h1, _ := Bytes([]byte(""))
-	h2, _ := Bytes([]byte(""))
-	score, _ := Compare(h1, h2)
+	// Bytes has no hash for empty data, and its empty result is no hash to compare
+	h, err := Bytes([]byte(""))
+	require.ErrorIs(t, err, ErrEmptyData)
+	_, err = Compare(h, h)
+	require.ErrorIs(t, err, ErrInvalidHash)
+
+	// The hash of no input from SumBytes compares like any other
+	h1 := SumBytes(nil)
+	h2 := SumBytes([]byte(""))
+	score, err := Compare(h1, h2)
+	require.NoError(t, err)
 	if score != 100 {
 		t.Errorf("Expected score 100 for empty strings, got %d", score)
 	}
 }
 
 func TestLargeSimilarity(t *testing.T) {
+	// Pseudo-random rather than periodic data: a period shorter than the input repeats
+	// the same chunks, leaving a single character of the hash to cover the changed byte
 	data1 := make([]byte, 10000)
+	x := uint32(1)
 	for i := range data1 {
-		data1[i] = byte(i % 256)
+		x = x*1664525 + 1013904223
+		data1[i] = byte(x >> 24)
 	}
 	data2 := make([]byte, 10000)
 	copy(data2, data1)
@@ -109,6 +220,21 @@ func BenchmarkHashBytes64K(b *testing.B) {
 	}
 }
 
+func BenchmarkSum(b *testing.B) {
+	data := make([]byte, 64*1024)
+	for i := range data {
+		data[i] = byte(i % 256)
+	}
+	state := newSSDeepState(estimateBlockSize(int64(len(data))))
+	defer state.Close()
+	state.Write(data)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = state.Sum()
+	}
+}
+
 func BenchmarkHashBytes1M(b *testing.B) {
 	data := make([]byte, 1024*1024)
 	for i := range data {
@@ -122,6 +248,48 @@ func BenchmarkHashBytes1M(b *testing.B) {
 	}
 }
 
+func BenchmarkSumBytes1M(b *testing.B) {
+	data := make([]byte, 1024*1024)
+	for i := range data {
+		data[i] = byte(i % 256)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = SumBytes(data)
+	}
+}
+
+// BenchmarkStateWrite measures the throughput of the inner loop of Write alone, on
+// random data that fills the segments only near the end
+func BenchmarkStateWrite(b *testing.B) {
+	data := make([]byte, 1024*1024)
+	_, err := rand.Read(data)
+	require.NoError(b, err)
+	state := newSSDeepState(estimateBlockSize(int64(len(data))))
+	defer state.Close()
+
+	b.SetBytes(int64(len(data)))
+	for b.Loop() {
+		state.reset(state.blockSize)
+		state.Write(data)
+	}
+}
+
+func TestSumBytes(t *testing.T) {
+	for _, size := range []int{1, 43, 4096, 1 << 20} {
+		data := make([]byte, size)
+		_, err := rand.Read(data)
+		require.NoError(t, err)
+
+		want, err := Bytes(data)
+		require.NoError(t, err)
+		require.Equal(t, want, SumBytes(data), "size %d", size)
+	}
+	require.Equal(t, "3::", SumBytes(nil))
+}
+
 func BenchmarkHashBytes10M(b *testing.B) {
 	data := make([]byte, 10*1024*1024)
 	for i := range data {
@@ -154,6 +322,47 @@ func BenchmarkCompare(b *testing.B) {
 	}
 }
 
+func TestCompareIdentical(t *testing.T) {
+	h := "49152:5AM11NN999r//99tt55JJtt0JCh9ZtB5FJB1BXh9ZtB5FJB1EpNajPZtLJXJvJ7x:PWDwVRXqpl5P0ncpK5WKFfwvSAvUl"
+	other := strings.Clone(h)
+
+	s, err := Compare(h, other)
+	require.NoError(t, err)
+	require.Equal(t, 100, s)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		_, _ = Compare(h, other)
+	})
+	require.Zero(t, allocs)
+
+	// Identical strings are still validated
+	for _, invalid := range []string{"", "garbage", "3:abc", ":"} {
+		s, err := Compare(invalid, invalid)
+		require.ErrorIs(t, err, ErrInvalidHash, "%q", invalid)
+		require.Zero(t, s)
+	}
+
+	// The empty string is no hash, whatever it is compared with
+	_, err = Compare("", h)
+	require.ErrorIs(t, err, ErrInvalidHash)
+}
+
+func BenchmarkCompareIdentical(b *testing.B) {
+	data := make([]byte, 10000)
+	for i := range data {
+		data[i] = byte(i % 256)
+	}
+
+	h1, _ := Bytes(data)
+	h2 := strings.Clone(h1)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = Compare(h1, h2)
+	}
+}
+
 func TestHash(t *testing.T) {
 	data := make([]byte, 10<<20)
 	_, err := rand.Read(data)
@@ -246,3 +455,924 @@ func TestCompareAgainstOfficialAlgorithm(t *testing.T) {
 		require.Equal(t, tc.score, s, "Score mismatch for %s vs %s", tc.h1, tc.h2)
 	}
 }
+
+func TestCompareSaturatedHash(t *testing.T) {
+	h1 := "49152:5AM11NN999r//99tt55JJtt0JCh9ZtB5FJB1BXh9ZtB5FJB1EpNajPZtLJXJvJ7x:PWDwVRXqpl5P0ncpK5WKFfwvSAvUl"
+	h2 := "49152:SAM11NN999r//99tt55JJtt0JCh9ZtB5FJB1BXh9ZtB5FJB1EpNajPZtLJXJvJ7n:SWDwVRXqpl5P0ncpK5WKFfwvSAvUb"
+
+	// Saturation is reported apart from the error, which Compare keeps for failures
+	s, err := Compare(h1, h2)
+	require.NoError(t, err)
+	require.Equal(t, 97, s)
+
+	s, info, err := CompareWithInfo(h1, h2)
+	require.NoError(t, err)
+	require.Equal(t, 97, s)
+	require.True(t, info.Saturated)
+
+	// Only one saturated segment is not reported
+	_, info, err = CompareWithInfo(h1, "49152:SAM11NN999r//99tt55JJtt0JCh9ZtB5FJB1BXh9ZtB5FJB1EpNajPZtLJXJ:SWDwVRXqpl5P0ncpK5WKFfwvSAvUb")
+	require.NoError(t, err)
+	require.False(t, info.Saturated)
+
+	// Nor are saturated segments computed at different block sizes
+	_, info, err = CompareWithInfo(h1, "98304:SAM11NN999r//99tt55JJtt0JCh9ZtB5FJB1BXh9ZtB5FJB1EpNajPZtLJXJvJ7n:SWDwVRXqpl5P0ncpK5WKFfwvSAvUb")
+	require.NoError(t, err)
+	require.False(t, info.Saturated)
+
+	_, _, err = CompareWithInfo(h1, "x")
+	require.ErrorIs(t, err, ErrInvalidHash)
+}
+
+func TestParse(t *testing.T) {
+	h, err := Parse("3:FJKKIUKact:FHIGi")
+	require.NoError(t, err)
+	require.Equal(t, HashInfo{BlockSize: 3, Part1: "FJKKIUKact", Part2: "FHIGi"}, h)
+
+	for _, s := range []string{"", "3", "3:abc", "3:a:b:c:d", "3:a:b:xyz", "x:a:b", "-3:a:b"} {
+		_, err = Parse(s)
+		require.ErrorIs(t, err, ErrInvalidHash, "Parse should fail for %q", s)
+	}
+}
+
+func TestParseWhitespace(t *testing.T) {
+	const clean = "3:FJKKIUKact:FHIGi"
+	want, err := Parse(clean)
+	require.NoError(t, err)
+
+	for name, hash := range map[string]string{
+		"BOM":            "\ufeff" + clean,
+		"trailing space": clean + "  ",
+		"leading tab":    "\t" + clean,
+		"CRLF":           clean + "\r\n",
+		"BOM and CRLF":   "\ufeff" + clean + "\r\n",
+	} {
+		h, err := Parse(hash)
+		require.NoError(t, err, name)
+		require.Equal(t, want, h, name)
+
+		_, err = ParseStrict(hash)
+		require.ErrorIs(t, err, ErrInvalidHash, name)
+
+		s, err := Compare(hash, "3:FJKKIrKact:FHIrGi")
+		require.NoError(t, err, name)
+		require.Equal(t, 71, s, name)
+	}
+
+	h, err := ParseStrict(clean)
+	require.NoError(t, err)
+	require.Equal(t, want, h)
+
+	// Whitespace inside a hash is not canonical either way
+	_, err = ParseStrict("3:FJKK IUKact:FHIGi")
+	require.ErrorIs(t, err, ErrInvalidHash)
+}
+
+func TestCompareSegments(t *testing.T) {
+	tests := []struct {
+		h1 string
+		h2 string
+	}{
+		{"3:FJKKIUKact:FHIGi", "3:FJKKIrKact:FHIrGi"},
+		{"12:hAnzB9Wp8+3vE+vP:hAnzhWp8jvE+vP", "24:hAnzhWp8jvE+vP:hAnzhWp8jvE+vP"},
+		{"24:hAnzhWp8jvE+vP:hAnzhWp8jvE+vP", "12:hAnzB9Wp8+3vE+vP:hAnzhWp8jvE+vP"},
+		{"3:FJKKIUKact:FHIGi", "12:hAnzB9Wp8+3vE+vP:hAnzhWp8jvE+vP"},
+	}
+
+	for _, tc := range tests {
+		expected, expectedErr := Compare(tc.h1, tc.h2)
+
+		a, err := Parse(tc.h1)
+		require.NoError(t, err)
+		b, err := Parse(tc.h2)
+		require.NoError(t, err)
+
+		s, err := CompareSegments(a.Part1, a.Part2, b.Part1, b.Part2, a.BlockSize, b.BlockSize)
+		require.Equal(t, expectedErr, err)
+		require.Equal(t, expected, s, "Score mismatch for %s vs %s", tc.h1, tc.h2)
+	}
+}
+
+func BenchmarkCompareSegments(b *testing.B) {
+	h1, _ := Parse("3:FJKKIUKact:FHIGi")
+	h2, _ := Parse("3:FJKKIrKact:FHIrGi")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = CompareSegments(h1.Part1, h1.Part2, h2.Part1, h2.Part2, h1.BlockSize, h2.BlockSize)
+	}
+}
+
+func TestCompareWeighted(t *testing.T) {
+	tests := []struct {
+		h1       string
+		h2       string
+		score    int
+		weighted int
+	}{
+		{
+			h1:       "12:hAnzB9Wp8+3vE+vP:hAnzhWp8jvE+vP",
+			h2:       "24:hAnzhWp8jvE+vP:hAnzhWp8jvE+vP",
+			score:    100,
+			weighted: 80,
+		},
+		{
+			h1:       "24:hAnzhWp8jvE+vP:hAnzhWp8jvE+vP",
+			h2:       "12:hAnzB9Wp8+3vE+vP:hAnzhWpXjvE+vP",
+			score:    97,
+			weighted: 77,
+		},
+		{
+			h1:       "12:hAnzB9Wp8+3vE+vP:hAnzhWp8jvE+vP",
+			h2:       "12:hAnzB9Wp8+3vE+vP:hAnzhWp8jvE+vP",
+			score:    100,
+			weighted: 100,
+		},
+	}
+
+	for _, tc := range tests {
+		s, err := Compare(tc.h1, tc.h2)
+		require.NoError(t, err)
+		require.Equal(t, tc.score, s, "Score mismatch for %s vs %s", tc.h1, tc.h2)
+
+		w, err := CompareWeighted(tc.h1, tc.h2)
+		require.NoError(t, err)
+		require.Equal(t, tc.weighted, w, "Weighted score mismatch for %s vs %s", tc.h1, tc.h2)
+	}
+}
+
+func TestAlgorithmVersion(t *testing.T) {
+	require.Equal(t, "ssdeep 2.14", AlgorithmVersion())
+}
+
+func TestCompatibleBlockSizes(t *testing.T) {
+	require.Equal(t, [3]int{0, 3, 6}, CompatibleBlockSizes(3))
+	require.Equal(t, [3]int{6, 12, 24}, CompatibleBlockSizes(12))
+
+	const segment = "hAnzhWp8jvE+vP"
+	compatible := CompatibleBlockSizes(12)
+	for _, bs := range []int{3, 6, 12, 24, 48, 96} {
+		h1 := "12:" + segment + ":" + segment
+		h2 := strconv.Itoa(bs) + ":" + segment + ":" + segment
+
+		s, err := Compare(h1, h2)
+		if slices.Contains(compatible[:], bs) {
+			require.NoError(t, err)
+			require.Equal(t, 100, s, "Block size %d should be comparable", bs)
+		} else {
+			require.ErrorIs(t, err, ErrIncompatibleBlockSizes, "Block size %d should not be comparable", bs)
+			require.Zero(t, s, "Block size %d should not be comparable", bs)
+		}
+	}
+}
+
+func TestHashSeed(t *testing.T) {
+	data, err := os.ReadFile("testdata/sample2.txt")
+	require.NoError(t, err)
+
+	standard, err := Stream(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	// The standard seed produces the standard hash
+	hash, err := Stream(bytes.NewReader(data), WithHashSeed(hashInit))
+	require.NoError(t, err)
+	require.Equal(t, standard, hash)
+
+	seeded, err := Stream(bytes.NewReader(data), WithHashSeed(0xdeadbeef))
+	require.NoError(t, err)
+	require.True(t, strings.HasSuffix(seeded, ":deadbeef"), seeded)
+	require.NotEqual(t, standard, strings.TrimSuffix(seeded, ":deadbeef"))
+
+	h, err := Parse(seeded)
+	require.NoError(t, err)
+	require.Equal(t, uint32(0xdeadbeef), h.Seed)
+	require.Equal(t, seeded, h.String())
+
+	s, err := Compare(seeded, seeded)
+	require.NoError(t, err)
+	require.Equal(t, 100, s)
+
+	_, err = Compare(standard, seeded)
+	require.ErrorIs(t, err, ErrSeedMismatch)
+
+	other, err := Stream(bytes.NewReader(data), WithHashSeed(1))
+	require.NoError(t, err)
+	_, err = Compare(other, seeded)
+	require.ErrorIs(t, err, ErrSeedMismatch)
+}
+
+func TestWithBlockSize(t *testing.T) {
+	data, err := os.ReadFile("testdata/sample2.txt")
+	require.NoError(t, err)
+
+	auto, err := Stream(bytes.NewReader(data))
+	require.NoError(t, err)
+	h, err := Parse(auto)
+	require.NoError(t, err)
+
+	// Forcing the estimated block size changes nothing
+	hash, err := Stream(bytes.NewReader(data), WithBlockSize(h.BlockSize))
+	require.NoError(t, err)
+	require.Equal(t, auto, hash)
+
+	hash, err = Stream(bytes.NewReader(data), WithBlockSize(48))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(hash, "48:"), hash)
+
+	// The caching path for unsized streams honors it too
+	cached, err := Stream(io.MultiReader(bytes.NewReader(data)), WithBlockSize(48))
+	require.NoError(t, err)
+	require.Equal(t, hash, cached)
+
+	for _, bs := range []uint32{1, 4, 9, 18} {
+		_, err = Stream(bytes.NewReader(data), WithBlockSize(bs))
+		require.ErrorIs(t, err, ErrInvalidBlockSize, "block size %d", bs)
+	}
+}
+
+func TestWithAdaptiveBlockSize(t *testing.T) {
+	// Repetitive data rarely hits a boundary at the estimated block size of 96
+	data := bytes.Repeat([]byte("abcdefghij"), 500)
+	standard, err := Bytes(data)
+	require.NoError(t, err)
+	require.Equal(t, "96:P:P", standard)
+
+	want, err := Bytes(data, WithBlockSize(48))
+	require.NoError(t, err)
+
+	hash, err := Bytes(data, WithAdaptiveBlockSize())
+	require.NoError(t, err)
+	require.Equal(t, want, hash)
+
+	// The caching path for unsized streams retries from its cache
+	hash, err = Stream(io.MultiReader(bytes.NewReader(data)), WithAdaptiveBlockSize())
+	require.NoError(t, err)
+	require.Equal(t, want, hash)
+
+	path := filepath.Join(t.TempDir(), "repetitive")
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+	hash, err = File(path, WithAdaptiveBlockSize())
+	require.NoError(t, err)
+	require.Equal(t, want, hash)
+
+	// The block size is halved until the first segment has spamSumLength/2 characters,
+	// as the official tool does, even past the point where it could score above 0
+	lines := bytes.Repeat([]byte("hello world\n"), 417)
+	hash, stats, err := SumWithStats(lines, WithAdaptiveBlockSize())
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, stats.Part1Len, spamSumLength/2, hash)
+	longer, err := Bytes(lines, WithBlockSize(stats.BlockSize*2))
+	require.NoError(t, err)
+	h, err := Parse(longer)
+	require.NoError(t, err)
+	require.Less(t, len(h.Part1), spamSumLength/2, longer)
+	bytesHash, err := Bytes(lines, WithAdaptiveBlockSize())
+	require.NoError(t, err)
+	require.Equal(t, hash, bytesHash)
+
+	// or reaches its minimum
+	hash, err = Bytes(make([]byte, 5000), WithAdaptiveBlockSize())
+	require.NoError(t, err)
+	require.Equal(t, "3::", hash)
+
+	// A forced block size is kept
+	hash, err = Bytes(data, WithAdaptiveBlockSize(), WithBlockSize(96))
+	require.NoError(t, err)
+	require.Equal(t, standard, hash)
+
+	// Hashes with long enough segments are unchanged
+	data, err = os.ReadFile("testdata/sample2.txt")
+	require.NoError(t, err)
+	want, err = Bytes(data)
+	require.NoError(t, err)
+	hash, err = Bytes(data, WithAdaptiveBlockSize())
+	require.NoError(t, err)
+	require.Equal(t, want, hash)
+}
+
+func TestWithNoPool(t *testing.T) {
+	data, err := os.ReadFile("testdata/sample2.txt")
+	require.NoError(t, err)
+
+	pooled, err := Stream(bytes.NewReader(data))
+	require.NoError(t, err)
+	hash, err := Stream(bytes.NewReader(data), WithNoPool())
+	require.NoError(t, err)
+	require.Equal(t, pooled, hash)
+
+	// Unsized streams take the caching path
+	hash, err = Stream(io.MultiReader(bytes.NewReader(data)), WithNoPool(), WithHashSeed(7))
+	require.NoError(t, err)
+	seeded, err := Stream(bytes.NewReader(data), WithHashSeed(7))
+	require.NoError(t, err)
+	require.Equal(t, seeded, hash)
+
+	opts := hashOptions{noPool: true}
+	state := opts.newState(minBlockSize)
+	require.True(t, state.unpooled)
+	require.NoError(t, state.Close())
+}
+
+func TestResetReplacesOversizedBuffers(t *testing.T) {
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	state := &ssdeepState{
+		hash1: make([]byte, 10, 1000),
+		hash2: make([]byte, 0, hashBufferCap),
+	}
+	state.reset(minBlockSize)
+	require.Equal(t, hashBufferCap, cap(state.hash1))
+	require.Equal(t, hashBufferCap, cap(state.hash2))
+	require.Empty(t, state.hash1)
+	require.Contains(t, logs.String(), "oversized hash buffers")
+
+	// Buffers of the expected capacity are kept, without a warning
+	logs.Reset()
+	hash1 := state.hash1
+	state.reset(minBlockSize)
+	require.Equal(t, &hash1[:1][0], &state.hash1[:1][0])
+	require.Empty(t, logs.String())
+}
+
+func TestConcurrentHashing(t *testing.T) {
+	inputs := make([][]byte, 16)
+	expected := make([]string, len(inputs))
+	for i := range inputs {
+		inputs[i] = []byte(strings.Repeat("The quick brown fox jumps over the lazy dog "+strconv.Itoa(i), (i+1)*40))
+		hash, err := Bytes(inputs[i])
+		require.NoError(t, err)
+		expected[i] = hash
+	}
+
+	// Run with -race to check that pooled states are never shared between goroutines
+	var wg sync.WaitGroup
+	errs := make(chan error, 64)
+	for g := range 64 {
+		wg.Go(func() {
+			for j := range 50 {
+				i := (g + j) % len(inputs)
+				var (
+					hash string
+					err  error
+				)
+				if j%2 == 0 {
+					hash, err = Bytes(inputs[i])
+				} else {
+					hash, err = Stream(io.MultiReader(bytes.NewReader(inputs[i])))
+				}
+				if err == nil && hash != expected[i] {
+					err = fmt.Errorf("input %d: got %s, want %s", i, hash, expected[i])
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+}
+
+func TestAnyMatch(t *testing.T) {
+	candidates := []string{
+		"invalid",
+		"3:AXA:B",
+		"3:FJKKIrKact:FHIrGi",
+		"3:FJKKIUKact:FHIGi",
+	}
+
+	idx, s, found := AnyMatch("3:FJKKIUKact:FHIGi", candidates, 50)
+	require.True(t, found)
+	require.Equal(t, 2, idx)
+	require.Equal(t, 71, s)
+
+	idx, s, found = AnyMatch("3:FJKKIUKact:FHIGi", candidates, 100)
+	require.True(t, found)
+	require.Equal(t, 3, idx)
+	require.Equal(t, 100, s)
+
+	idx, _, found = AnyMatch("3:FJKKIUKact:FHIGi", candidates[:2], 1)
+	require.False(t, found)
+	require.Equal(t, -1, idx)
+
+	_, _, found = AnyMatch("invalid", candidates, 0)
+	require.False(t, found)
+}
+
+func TestCompareMany(t *testing.T) {
+	candidates := []string{
+		"invalid",
+		"3:AXA:B",
+		"3:FJKKIrKact:FHIrGi",
+		"3:FJKKIUKact:FHIGi",
+		"3:FJKKIUKact:FHIGi:7",
+	}
+
+	results, err := CompareMany("3:FJKKIUKact:FHIGi", candidates, 50)
+	require.NoError(t, err)
+	require.Equal(t, []CompareResult{
+		{Index: 2, Match: "3:FJKKIrKact:FHIrGi", MatchInfo: HashInfo{BlockSize: 3, Part1: "FJKKIrKact", Part2: "FHIrGi"}, Score: 71},
+		{Index: 3, Match: "3:FJKKIUKact:FHIGi", MatchInfo: HashInfo{BlockSize: 3, Part1: "FJKKIUKact", Part2: "FHIGi"}, Score: 100},
+	}, results)
+
+	results, err = CompareMany("3:FJKKIUKact:FHIGi", candidates, 0)
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+
+	_, err = CompareMany("invalid", candidates, 0)
+	require.ErrorIs(t, err, ErrInvalidHash)
+}
+
+func TestCompareSlice(t *testing.T) {
+	templates := []string{
+		"3:FJKKIUKact:FHIGi",
+		"3:FJKKIrKact:FHIrGi",
+		"3:AXA:B",
+		"invalid",
+	}
+	var queries, candidates []string
+	for i := range 200 {
+		queries = append(queries, templates[i%2])
+		candidates = append(candidates, templates[i%len(templates)])
+	}
+
+	var calls int
+	counting := func(h1, h2 HashInfo) (int, error) {
+		calls++
+		return compareParsed(h1, h2)
+	}
+	scores := compareSlice(queries, candidates, counting)
+	// 2 distinct queries against 3 distinct valid candidates
+	require.Equal(t, 6, calls)
+
+	require.Len(t, scores, len(queries))
+	for i, q := range queries {
+		require.Len(t, scores[i], len(candidates))
+		for j, c := range candidates {
+			expected, err := Compare(q, c)
+			if err != nil {
+				expected = 0
+			}
+			require.Equal(t, expected, scores[i][j], "%s vs %s", q, c)
+		}
+	}
+
+	require.Equal(t, [][]int{{71, 100}}, CompareSlice(templates[1:2], templates[:2]))
+	require.Empty(t, CompareSlice(nil, templates))
+}
+
+func TestCompareManyParsed(t *testing.T) {
+	var candidates []HashInfo
+	for _, hash := range []string{"3:AXA:B", "3:FJKKIrKact:FHIrGi", "3:FJKKIUKact:FHIGi", "3:FJKKIUKact:FHIGi:7"} {
+		h, err := Parse(hash)
+		require.NoError(t, err)
+		candidates = append(candidates, h)
+	}
+	target := candidates[2]
+
+	results, err := CompareManyParsed(target, candidates, 50)
+	require.NoError(t, err)
+	require.Equal(t, []CompareResult{
+		{Index: 1, MatchInfo: candidates[1], Score: 71},
+		{Index: 2, MatchInfo: candidates[2], Score: 100},
+	}, results)
+
+	target.Part1 = strings.Repeat("A", maxSegmentLength+1)
+	_, err = CompareManyParsed(target, candidates, 0)
+	require.ErrorIs(t, err, ErrSegmentTooLong)
+}
+
+// compareManyCandidates returns n distinct hashes of similar inputs
+func compareManyCandidates(b *testing.B, n int) (string, []string) {
+	data := make([]byte, 10000)
+	for i := range data {
+		data[i] = byte(i % 256)
+	}
+	target, err := Bytes(data)
+	require.NoError(b, err)
+
+	candidates := make([]string, n)
+	for i := range candidates {
+		data[(i*37)%len(data)] ^= byte(i)
+		candidates[i], err = Bytes(data)
+		require.NoError(b, err)
+	}
+	return target, candidates
+}
+
+func BenchmarkCompareMany(b *testing.B) {
+	target, candidates := compareManyCandidates(b, 10000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = CompareMany(target, candidates, 101)
+	}
+}
+
+func BenchmarkCompareManyParsed(b *testing.B) {
+	target, candidates := compareManyCandidates(b, 10000)
+	t, err := Parse(target)
+	require.NoError(b, err)
+	parsed := make([]HashInfo, len(candidates))
+	for i, c := range candidates {
+		parsed[i], err = Parse(c)
+		require.NoError(b, err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = CompareManyParsed(t, parsed, 101)
+	}
+}
+
+// BenchmarkCompareManyLoop is the baseline for BenchmarkCompareMany, parsing target on every comparison
+func BenchmarkCompareManyLoop(b *testing.B) {
+	target, candidates := compareManyCandidates(b, 10000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, c := range candidates {
+			_, _ = Compare(target, c)
+		}
+	}
+}
+
+func TestCompareEmptySegments(t *testing.T) {
+	const seg = "FJKKIUKact"
+
+	// Every combination of empty and non-empty segments in both hashes
+	for mask := range 16 {
+		parts := [4]string{}
+		for i := range parts {
+			if mask&(1<<i) != 0 {
+				parts[i] = seg
+			}
+		}
+		part1a, part2a, part1b, part2b := parts[0], parts[1], parts[2], parts[3]
+
+		expected := 0
+		if (part1a != "" && part1b != "") || (part2a != "" && part2b != "") {
+			expected = 100
+		}
+		s, err := CompareSegments(part1a, part2a, part1b, part2b, 3, 3)
+		require.NoError(t, err)
+		require.Equal(t, expected, s, "%q:%q vs %q:%q", part1a, part2a, part1b, part2b)
+	}
+
+	// An empty second segment no longer matches another empty one
+	s, err := Compare("3:AXA:", "3:BXB:")
+	require.NoError(t, err)
+	require.Zero(t, s)
+
+	// Identical hashes are still identical
+	s, err = Compare("3::", "3::")
+	require.NoError(t, err)
+	require.Equal(t, 100, s)
+}
+
+func TestShrinkRun(t *testing.T) {
+	const s = "AAAAAbbCCCCd"
+	require.Equal(t, "AAAbbCCCd", string(shrink(s, defaultScoreConfig.shrinkRun, nil)))
+	require.Equal(t, "AbCd", string(shrink(s, 1, nil)))
+	require.Equal(t, "AAbbCCd", string(shrink(s, 2, nil)))
+	require.Equal(t, s, string(shrink(s, 5, nil)))
+
+	// A run of 4 survives whole with factor 4, so the score against a run of 3 drops
+	h1, h2 := "FAAAAJKKIUKact", "FAAAJKKIUKact"
+	require.Equal(t, 100, defaultScoreConfig.score(h1, h2, 3))
+	custom := scoreConfig{shrinkRun: 4}
+	require.Less(t, custom.score(h1, h2, 3), 100)
+}
+
+func TestShrinkSWAR(t *testing.T) {
+	inputs := []string{
+		"",
+		"A",
+		"AAAAAbbCCCCd",
+		"FJKKIUKact",
+		"M3+4CDTfWRcyNEqrBFWMEWM8XJ",
+		strings.Repeat("A", spamSumLength),
+		strings.Repeat("ABCDEFGH", 8),
+		strings.Repeat("ABCDEFGHH", 7) + "HHHH",
+		"ABCDEFGAAAAAAAAAAAAABCDEFGHIJKLMNNNNOP",
+	}
+	for range 1000 {
+		// Random strings over a small alphabet have many runs
+		b := make([]byte, 1+len(inputs)%spamSumLength)
+		_, err := rand.Read(b)
+		require.NoError(t, err)
+		for i := range b {
+			b[i] = "ABC"[b[i]%3]
+		}
+		inputs = append(inputs, string(b))
+	}
+
+	for _, s := range inputs {
+		for run := 1; run <= 4; run++ {
+			require.Equal(t, string(shrink(s, run, nil)), string(shrinkSWAR(s, run, nil)), "%q run %d", s, run)
+		}
+	}
+}
+
+func BenchmarkShrink(b *testing.B) {
+	for name, s := range map[string]string{
+		"distinct": "xR7mN7O8P9Q0R1S2T3U4V5W6X7Y8Z9a0b1c2d3e4f5g6h7i8j9k0l1m2n3o4pqr",
+		"runs":     strings.Repeat("AAAAB", 12) + "CCCC",
+	} {
+		var buf [spamSumLength]byte
+		b.Run(name+"/bytewise", func(b *testing.B) {
+			for b.Loop() {
+				shrink(s, 3, buf[:0])
+			}
+		})
+		b.Run(name+"/swar", func(b *testing.B) {
+			for b.Loop() {
+				shrinkSWAR(s, 3, buf[:0])
+			}
+		})
+	}
+}
+
+// FuzzScore feeds segments of any length to score, whose shrink buffers live on the
+// stack up to spamSumLength characters and must grow on the heap past it
+func FuzzScore(f *testing.F) {
+	f.Add("FJKKIUKact", "FHIGi")
+	f.Add(strings.Repeat("AAAAB", 13), strings.Repeat("AAAAC", 13))
+	f.Add(strings.Repeat("FJKKIUKactM3+4CDTfWRcyNEqrBFWMEWM8XJ", 6), strings.Repeat("FJKKIUKactM3+4CDTfWRcyNEqrBFWMEWM8Xj", 5))
+	f.Add(strings.Repeat("A", 200), "")
+
+	damerau := defaultScoreConfig
+	damerau.damerau = true
+
+	f.Fuzz(func(t *testing.T, s1, s2 string) {
+		for _, s := range []string{s1, s2} {
+			var buf [spamSumLength]byte
+			shrunk := shrinkSWAR(s, defaultScoreConfig.shrinkRun, buf[:0])
+			require.LessOrEqual(t, len(shrunk), len(s))
+			require.Equal(t, string(shrink(s, defaultScoreConfig.shrinkRun, nil)), string(shrunk))
+		}
+
+		for _, cfg := range []scoreConfig{defaultScoreConfig, damerau} {
+			score := cfg.score(s1, s2, minBlockSize)
+			require.GreaterOrEqual(t, score, 0)
+			require.LessOrEqual(t, score, 100)
+			require.Equal(t, score, cfg.score(s2, s1, minBlockSize))
+		}
+
+		score, err := CompareSegments(s1, s2, s1, s2, minBlockSize, minBlockSize)
+		if max(len(s1), len(s2)) > maxSegmentLength {
+			require.ErrorIs(t, err, ErrSegmentTooLong)
+			return
+		}
+		require.GreaterOrEqual(t, score, 0)
+		require.LessOrEqual(t, score, 100)
+	})
+}
+
+func TestCompareLongSegments(t *testing.T) {
+	long := strings.Repeat("FJKKIUKactM3+4CDTfWRcyNEqrBFWMEWM8XJ", 2) // 72 characters
+	// Only characters past spamSumLength differ, so truncation would hide the change
+	changed := long[:66] + "xxxxx" + long[71:]
+
+	s, err := Compare("3:FHIGi:"+long, "6:"+changed+":FHIGi")
+	require.NoError(t, err)
+	require.Greater(t, s, 90)
+	require.Less(t, s, 100)
+
+	tooLong := strings.Repeat("A", maxSegmentLength+1)
+	_, err = Compare("3:"+tooLong+":FHIGi", "3:"+long+":FHIGi")
+	require.ErrorIs(t, err, ErrSegmentTooLong)
+}
+
+func TestCompareBothDirections(t *testing.T) {
+	// Only the 4x pairing (6:part2 against 3:part1) matches
+	h1 := "6:FJKKIUKactFJKKIUKact:hAnzhWp8jvE+vP"
+	h2 := "3:hAnzhWp8jvE+vP:FHIGi"
+
+	s, err := Compare(h1, h2)
+	require.NoError(t, err)
+	require.Zero(t, s)
+
+	for _, pair := range [][2]string{{h1, h2}, {h2, h1}} {
+		s, err = CompareWithOptions(pair[0], pair[1], WithHashBothDirections())
+		require.NoError(t, err)
+		require.Equal(t, 100, s)
+	}
+
+	// Without the option CompareWithOptions matches Compare
+	s, err = CompareWithOptions(h1, h2)
+	require.NoError(t, err)
+	require.Zero(t, s)
+}
+
+func TestEstimateDifference(t *testing.T) {
+	for score, expected := range map[int]float64{
+		100: 0,
+		99:  0.02,
+		94:  0.12,
+		90:  0.2,
+		75:  0.5,
+		60:  0.8,
+		50:  1,
+		0:   1,
+		-1:  1,
+		101: 0,
+	} {
+		require.InDelta(t, expected, EstimateDifference(score), 1e-9, "score %d", score)
+	}
+}
+
+func TestDamerauLevenshtein(t *testing.T) {
+	tests := []struct {
+		s1, s2      string
+		levenshtein int
+		damerau     int
+	}{
+		{"", "abc", 3, 3},
+		{"abc", "abc", 0, 0},
+		{"ab", "ba", 2, 1},
+		{"abcdef", "badcfe", 4, 3},
+		{"ca", "abc", 3, 3}, // optimal string alignment edits no substring twice
+		{"kitten", "sitting", 3, 3},
+	}
+	for _, tc := range tests {
+		require.Equal(t, tc.levenshtein, levenshtein([]byte(tc.s1), []byte(tc.s2)), "%q %q", tc.s1, tc.s2)
+		require.Equal(t, tc.damerau, damerauLevenshtein([]byte(tc.s1), []byte(tc.s2)), "%q %q", tc.s1, tc.s2)
+		require.Equal(t, tc.damerau, damerauLevenshtein([]byte(tc.s2), []byte(tc.s1)), "%q %q", tc.s2, tc.s1)
+	}
+
+	// Segments longer than spamSumLength use heap rows
+	long := strings.Repeat("ab", spamSumLength)
+	require.Equal(t, 1, damerauLevenshtein([]byte(long), []byte("ba"+long[2:])))
+}
+
+func TestCompareDamerau(t *testing.T) {
+	h1 := "3:M3+4CDTfWRcyNEqrBFWMEWM8XJ:FHIGi"
+	// Adjacent characters swapped in three places
+	h2 := "3:3M+4CDTfWRycNEqrBFWMEWMX8J:B"
+
+	standard, err := CompareWithOptions(h1, h2)
+	require.NoError(t, err)
+	require.Equal(t, 90, standard)
+	damerau, err := CompareWithOptions(h1, h2, WithDamerauLevenshtein())
+	require.NoError(t, err)
+	require.Equal(t, 96, damerau)
+
+	s, err := CompareWithOptions(h1, h1, WithDamerauLevenshtein())
+	require.NoError(t, err)
+	require.Equal(t, 100, s)
+}
+
+func TestCompareShortPenalty(t *testing.T) {
+	// Both first segments have 10 characters, one edit apart, and the second ones are too short to score
+	const h1, h2 = "3:FJKKIUKact:FHIGi", "3:FJKKIrKact:FHIrGi"
+
+	s, err := Compare(h1, h2)
+	require.NoError(t, err)
+	require.Equal(t, 71, s, "10 * 100 / 14")
+
+	for _, tc := range []struct {
+		penalty ShortPenalty
+		score   int
+	}{
+		{ShortPenalty{Length: 11, Divisor: 14}, 71}, // The reference penalty
+		{ShortPenalty{Length: 11, Divisor: 20}, 50}, // 10 * 100 / 20
+		{ShortPenalty{Length: 10, Divisor: 20}, 96}, // 10 characters are no longer short
+		{ShortPenalty{}, 96},                        // No penalty, the unscaled score
+	} {
+		s, err := CompareWithOptions(h1, h2, WithShortPenalty(tc.penalty))
+		require.NoError(t, err)
+		require.Equal(t, tc.score, s, "%+v", tc.penalty)
+	}
+
+	// Long segments are unaffected
+	long1 := "96:bLQvsVmZnuzS3sYW5TYkBApUM6QlEarhbJ9G+0Go:bLQvsVmZ0CkBApz6QlE6N9R0n"
+	long2 := "96:bLQvsVmZnuzS3sYW5TYkBApUM6QlEarhbJ9G+0Gx:bLQvsVmZ0CkBApz6QlE6N9R0x"
+	want, err := Compare(long1, long2)
+	require.NoError(t, err)
+	s, err = CompareWithOptions(long1, long2, WithShortPenalty(ShortPenalty{Length: 11, Divisor: 100}))
+	require.NoError(t, err)
+	require.Equal(t, want, s)
+}
+
+func TestHashFullSegments(t *testing.T) {
+	data := make([]byte, 1<<20)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+
+	// The minimum block size fills both segments long before the end. Like the official
+	// tool, the remaining data then only changes the last character of each segment.
+	state := newSSDeepState(minBlockSize)
+	defer state.Close()
+	state.Write(data[:len(data)/2])
+	require.Len(t, state.hash1, part1Chunks)
+	require.Len(t, state.hash2, part2Chunks)
+	half, err := Parse(state.Sum())
+	require.NoError(t, err)
+	require.Len(t, half.Part1, spamSumLength)
+	require.Len(t, half.Part2, spamSumLength/2)
+
+	state.Write(data[len(data)/2:])
+	full, err := Parse(state.Sum())
+	require.NoError(t, err)
+	require.Equal(t, half.Part1[:part1Chunks], full.Part1[:part1Chunks])
+	require.Equal(t, half.Part2[:part2Chunks], full.Part2[:part2Chunks])
+	require.Len(t, full.Part1, spamSumLength)
+	require.Len(t, full.Part2, spamSumLength/2)
+	require.Equal(t, int64(len(data)), state.bytesWritten())
+
+	// The rolling hash keeps going after that, counting every boundary. A block size
+	// too large to ever fill the segments gives the same rolling hash, from which the
+	// boundaries at the minimum block size are counted one byte at a time.
+	ref := newSSDeepState(minBlockSize << 20)
+	defer ref.Close()
+	var triggers1, triggers2 uint64
+	for _, c := range data {
+		ref.Write([]byte{c})
+		h := ref.h1 + ref.h2 + ref.h3
+		if h%minBlockSize == minBlockSize-1 {
+			triggers1++
+		}
+		if h%(2*minBlockSize) == 2*minBlockSize-1 {
+			triggers2++
+		}
+	}
+	require.Less(t, len(ref.hash1), part1Chunks)
+	require.Equal(t, [3]uint32{ref.h1, ref.h2, ref.h3}, [3]uint32{state.h1, state.h2, state.h3})
+	require.Equal(t, triggers1, state.triggers1)
+	require.Equal(t, triggers2, state.triggers2)
+}
+
+func BenchmarkHashFullSegments(b *testing.B) {
+	data := make([]byte, 10<<20)
+	_, _ = rand.Read(data)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		state := newSSDeepState(minBlockSize)
+		_, _ = state.Write(data)
+		_ = state.Sum()
+		state.Close()
+	}
+}
+
+func TestDebug(t *testing.T) {
+	data, err := os.ReadFile("testdata/sample2.txt")
+	require.NoError(t, err)
+
+	hash, info, err := Debug(data)
+	require.NoError(t, err)
+	require.Equal(t, "3:M3+4CDTfWRcyNEqrBFWMEWM8XJ:M3KDKKqzZEL8XJ", hash)
+	require.Equal(t, DebugInfo{
+		BlockSize:  3,
+		Part1Len:   26,
+		Part2Len:   14,
+		TotalBytes: int64(len(data)),
+		Triggers1:  25,
+		Triggers2:  13,
+	}, info)
+
+	_, _, err = Debug(nil)
+	require.ErrorIs(t, err, ErrEmptyData)
+}
+
+func TestSumWithStats(t *testing.T) {
+	data := []byte("The quick brown fox jumps over the lazy dog")
+	hash, stats, err := SumWithStats(data)
+	require.NoError(t, err)
+	require.Equal(t, "3:FJKKIUKact:FHIGi", hash)
+	require.Equal(t, Stats{BytesProcessed: 43, BlockSize: 3, Part1Len: 10, Part2Len: 5}, stats)
+
+	// Each adaptive pass is counted
+	data = bytes.Repeat([]byte("abcdefghij"), 500)
+	want, err := Bytes(data, WithAdaptiveBlockSize())
+	require.NoError(t, err)
+	hash, stats, err = SumWithStats(data, WithAdaptiveBlockSize())
+	require.NoError(t, err)
+	require.Equal(t, want, hash)
+	require.Equal(t, Stats{BytesProcessed: 10000, BlockSize: 48, Halvings: 1, Part1Len: 64, Part2Len: 1}, stats)
+
+	hash, stats, err = SumWithStats(nil)
+	require.NoError(t, err)
+	require.Equal(t, "3::", hash)
+	require.Equal(t, Stats{BlockSize: 3}, stats)
+
+	// An invalid block size fails as with Bytes
+	_, err = Bytes(data, WithBlockSize(10))
+	require.ErrorIs(t, err, ErrInvalidBlockSize)
+	_, _, err = SumWithStats(data, WithBlockSize(10))
+	require.ErrorIs(t, err, ErrInvalidBlockSize)
+	hash, stats, err = SumWithStats(data, WithBlockSize(96))
+	require.NoError(t, err)
+	require.Equal(t, uint32(96), stats.BlockSize)
+	require.True(t, strings.HasPrefix(hash, "96:"), hash)
+}
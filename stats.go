@@ -0,0 +1,58 @@
+package ssdeep
+
+import (
+	"math"
+	"slices"
+)
+
+// ScoreHistogram counts results by score, for summarizing the output of a bulk comparison.
+// result[i] is the number of results scoring in [i*100/buckets, (i+1)*100/buckets), with
+// the bounds rounded down, except that the last bucket also counts scores of 100.
+// It returns nil when buckets is not positive.
+func ScoreHistogram(results []CompareResult, buckets int) []int {
+	if buckets <= 0 {
+		return nil
+	}
+
+	counts := make([]int, buckets)
+	for _, r := range results {
+		s := min(max(r.Score, 0), 100)
+		// The last bucket whose lower bound, i*100/buckets, does not exceed s
+		i := ((s+1)*buckets+99)/100 - 1
+		counts[min(i, buckets-1)]++
+	}
+	return counts
+}
+
+// ScoreStats returns the smallest, largest, mean and median score of results and their
+// population standard deviation. The median of an even number of results is the mean of
+// the two middle scores. All values are 0 for no results.
+func ScoreStats(results []CompareResult) (min, max, mean, median, stddev float64) {
+	if len(results) == 0 {
+		return 0, 0, 0, 0, 0
+	}
+
+	scores := make([]int, len(results))
+	var sum float64
+	for i, r := range results {
+		scores[i] = r.Score
+		sum += float64(r.Score)
+	}
+	slices.Sort(scores)
+
+	n := len(scores)
+	mean = sum / float64(n)
+	median = float64(scores[n/2])
+	if n%2 == 0 {
+		median = float64(scores[n/2-1]+scores[n/2]) / 2
+	}
+
+	var squares float64
+	for _, s := range scores {
+		d := float64(s) - mean
+		squares += d * d
+	}
+	stddev = math.Sqrt(squares / float64(n))
+
+	return float64(scores[0]), float64(scores[n-1]), mean, median, stddev
+}
@@ -0,0 +1,43 @@
+package ssdeep
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func scoreResults(scores ...int) []CompareResult {
+	results := make([]CompareResult, len(scores))
+	for i, s := range scores {
+		results[i] = CompareResult{Index: i, Score: s}
+	}
+	return results
+}
+
+func TestScoreHistogram(t *testing.T) {
+	results := scoreResults(0, 9, 10, 55, 99, 100)
+	require.Equal(t, []int{2, 1, 0, 0, 0, 1, 0, 0, 0, 2}, ScoreHistogram(results, 10))
+	require.Equal(t, []int{6}, ScoreHistogram(results, 1))
+
+	// Bounds are rounded down: [0, 33), [33, 66), [66, 100]
+	require.Equal(t, []int{1, 2, 1}, ScoreHistogram(scoreResults(32, 33, 65, 66), 3))
+
+	require.Nil(t, ScoreHistogram(results, 0))
+	require.Equal(t, []int{0, 0}, ScoreHistogram(nil, 2))
+}
+
+func TestScoreStats(t *testing.T) {
+	lo, hi, mean, median, stddev := ScoreStats(scoreResults(90, 10, 50, 30))
+	require.Equal(t, 10.0, lo)
+	require.Equal(t, 90.0, hi)
+	require.Equal(t, 45.0, mean)
+	require.Equal(t, 40.0, median)
+	require.InDelta(t, 29.5804, stddev, 1e-4)
+
+	_, _, _, median, stddev = ScoreStats(scoreResults(70, 100, 70))
+	require.Equal(t, 70.0, median)
+	require.InDelta(t, 14.1421, stddev, 1e-4)
+
+	lo, hi, mean, median, stddev = ScoreStats(nil)
+	require.Zero(t, lo+hi+mean+median+stddev)
+}
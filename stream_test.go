@@ -2,9 +2,14 @@ package ssdeep
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io"
+	"os"
 	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -38,7 +43,8 @@ func TestStreamReaderFileCache(t *testing.T) {
 	for i := range data {
 		data[i] = byte(i % 256)
 	}
-	reader := bytes.NewReader(data)
+	// MultiReader hides Seek, so the data has to be cached
+	reader := io.MultiReader(bytes.NewReader(data))
 
 	sr := newStreamReader(reader, minCachedSize, true)
 	defer sr.Close()
@@ -133,3 +139,582 @@ func BenchmarkStreamFileCache(b *testing.B) {
 		_, _ = Stream(reader)
 	}
 }
+
+// BenchmarkStreamSpill hashes a stream too large for the memory cache, with and
+// without a length hint to preallocate the temporary file from
+func BenchmarkStreamSpill(b *testing.B) {
+	data := make([]byte, 8*1024*1024) // 8MB
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	b.Run("Hinted", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		for b.Loop() {
+			_, _ = Stream(bytes.NewBuffer(data))
+		}
+	})
+	b.Run("Unhinted", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		for b.Loop() {
+			_, _ = Stream(struct{ io.Reader }{bytes.NewReader(data)})
+		}
+	})
+}
+
+// lenReader reports a remaining length that need not match the data
+type lenReader struct {
+	io.Reader
+	n int
+}
+
+func (r lenReader) Len() int { return r.n }
+
+func TestStreamSpillSizeHint(t *testing.T) {
+	data := make([]byte, 3*minCachedSize)
+	for i := range data {
+		data[i] = byte(i * 7 % 253)
+	}
+	want, err := Bytes(data)
+	require.NoError(t, err)
+
+	for _, n := range []int{len(data), 0, len(data) / 2, 4 * len(data)} {
+		var spill string
+		hash, err := Stream(lenReader{bytes.NewReader(data), n}, WithCachedSize(minCachedSize), WithKeepSpill(&spill))
+		require.NoError(t, err)
+		require.Equal(t, want, hash, "hint %d", n)
+
+		// An overestimated hint reserves space without growing the file
+		require.NotEmpty(t, spill)
+		info, err := os.Stat(spill)
+		require.NoError(t, err)
+		require.Equal(t, int64(len(data)), info.Size(), "hint %d", n)
+		require.NoError(t, os.Remove(spill))
+	}
+}
+
+// slowReader yields one byte per read after a delay
+type slowReader struct {
+	delay time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	time.Sleep(r.delay)
+	if len(p) == 0 {
+		return 0, nil
+	}
+	p[0] = 'a'
+	return 1, nil
+}
+
+func TestStreamContextTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := StreamContext(ctx, &slowReader{delay: 5 * time.Millisecond})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func TestFileContext(t *testing.T) {
+	hash, err := FileContext(context.Background(), "testdata/sample1.txt")
+	require.NoError(t, err)
+
+	expectedHash, err := File("testdata/sample1.txt")
+	require.NoError(t, err)
+	require.Equal(t, expectedHash, hash)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = FileContext(ctx, "testdata/sample1.txt")
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestStreamReaderAnonymousTempFile(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	data := make([]byte, int(minCachedSize)+1024)
+	sr := newStreamReader(io.MultiReader(bytes.NewReader(data)), minCachedSize, false)
+	require.NoError(t, sr.ReadAll())
+	require.NotNil(t, sr.file)
+
+	entries, err := os.ReadDir(tmp)
+	require.NoError(t, err)
+	if !sr.named {
+		require.Empty(t, entries, "Anonymous temp file should have no directory entry")
+	}
+
+	require.NoError(t, sr.Close())
+	entries, err = os.ReadDir(tmp)
+	require.NoError(t, err)
+	require.Empty(t, entries, "Temp file should be gone after Close")
+}
+
+func TestStreamReaderCleanupRemovesTempFile(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	t.Setenv("TMP", tmp) // Windows
+
+	data := make([]byte, int(minCachedSize)+1024)
+	sr := newStreamReader(io.MultiReader(bytes.NewReader(data)), minCachedSize, true)
+	require.NoError(t, sr.ReadAll())
+	require.NotNil(t, sr.file)
+
+	require.NoError(t, sr.Close())
+	entries, err := os.ReadDir(tmp)
+	require.NoError(t, err)
+	require.Empty(t, entries, "Temp file should be gone after Close")
+}
+
+func TestCachedSizeStrict(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	data := make([]byte, 100<<10)
+	for i := range data {
+		data[i] = byte(i * 7 % 253)
+	}
+	want, err := Bytes(data)
+	require.NoError(t, err)
+
+	stream := func(options ...Option) (hash, spill string) {
+		hash, err := Stream(io.MultiReader(bytes.NewReader(data)), append(options, WithKeepSpill(&spill))...)
+		require.NoError(t, err)
+		if spill != "" {
+			require.NoError(t, os.Remove(spill))
+		}
+		return hash, spill
+	}
+
+	// WithCachedSize raises 64 KiB to the 128 KiB floor, so 100 KiB stays in memory
+	hash, spill := stream(WithCachedSize(64 << 10))
+	require.Equal(t, want, hash)
+	require.Empty(t, spill)
+
+	hash, spill = stream(WithCachedSizeStrict(64 << 10))
+	require.Equal(t, want, hash)
+	require.NotEmpty(t, spill, "a 64 KiB cache should spill 100 KiB to disk")
+
+	// The last cache size option wins
+	_, spill = stream(WithCachedSizeStrict(64<<10), WithCachedSize(64<<10))
+	require.Empty(t, spill)
+
+	// Sizes below the safe minimum are raised to it
+	opts := streamOptions([]Option{WithCachedSizeStrict(1)})
+	require.Equal(t, int64(minStrictCachedSize), opts.cachedSize)
+}
+
+func TestStreamReaderPeek(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	data := make([]byte, int(minCachedSize)+1024)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	small := data[:100]
+
+	for name, tc := range map[string]struct {
+		r    io.Reader
+		data []byte
+	}{
+		"memory":   {io.MultiReader(bytes.NewReader(small)), small},
+		"file":     {io.MultiReader(bytes.NewReader(data)), data},
+		"seekable": {bytes.NewReader(data), data},
+	} {
+		sr := newStreamReader(tc.r, minCachedSize, false)
+
+		// Peek caches the stream on first use
+		magic, err := sr.Peek(4)
+		require.NoError(t, err, name)
+		require.Equal(t, tc.data[:4], magic, name)
+		require.NoError(t, sr.ReadAll(), name)
+		require.NoError(t, sr.Reset(), name)
+
+		// A read in progress is not disturbed
+		head := make([]byte, 10)
+		_, err = io.ReadFull(sr, head)
+		require.NoError(t, err, name)
+		all, err := sr.Peek(len(tc.data) + 10)
+		require.NoError(t, err, name)
+		require.Equal(t, tc.data, all, "%s: a short stream is returned whole", name)
+		rest, err := io.ReadAll(sr)
+		require.NoError(t, err, name)
+		require.Equal(t, tc.data[10:], rest, name)
+
+		// What Peek returns is what hashing processes
+		require.NoError(t, sr.Reset(), name)
+		hashed, err := io.ReadAll(sr)
+		require.NoError(t, err, name)
+		require.Equal(t, all, hashed, name)
+
+		empty, err := sr.Peek(0)
+		require.NoError(t, err, name)
+		require.Empty(t, empty, name)
+		require.NoError(t, sr.Close(), name)
+	}
+}
+
+func TestStreamReaderSeekableSource(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	data := make([]byte, int(minCachedSize)+1024)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	reader := bytes.NewReader(data)
+	_, err := reader.Seek(10, io.SeekStart)
+	require.NoError(t, err)
+
+	sr := newStreamReader(reader, minCachedSize, false)
+	defer sr.Close()
+	require.NoError(t, sr.ReadAll())
+	require.Equal(t, int64(len(data)-10), sr.Size())
+	require.Nil(t, sr.file, "Seekable source should not spill to a file")
+	require.Empty(t, sr.cached, "Seekable source should not be cached in memory")
+
+	entries, err := os.ReadDir(tmp)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	// Reset rewinds to where the source started, not to offset zero
+	for range 2 {
+		require.NoError(t, sr.Reset())
+		result, err := io.ReadAll(sr)
+		require.NoError(t, err)
+		require.Equal(t, data[10:], result)
+	}
+}
+
+func TestStreamBytesReader(t *testing.T) {
+	for _, size := range []int{1, 100, 4096, 100000, int(minCachedSize) + 1024} {
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(i * 7 % 251)
+		}
+
+		want, err := Bytes(data)
+		require.NoError(t, err)
+		got, err := Stream(bytes.NewReader(data))
+		require.NoError(t, err)
+		require.Equal(t, want, got, "size %d", size)
+
+		bytesAllocs := testing.AllocsPerRun(10, func() { _, _ = Bytes(data) })
+		streamAllocs := testing.AllocsPerRun(10, func() { _, _ = Stream(bytes.NewReader(data)) })
+		require.Equal(t, bytesAllocs, streamAllocs, "size %d", size)
+	}
+}
+
+func TestStreamReaderEmptyRead(t *testing.T) {
+	data := []byte("short")
+	sr := newStreamReader(bytes.NewReader(data), defaultCachedSize, false)
+	defer sr.Close()
+	require.NoError(t, sr.ReadAll())
+
+	n, err := sr.Read(nil)
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	// A short destination buffer gets exactly its length
+	buf := make([]byte, 3)
+	n, err = sr.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+	require.Equal(t, data[:3], buf)
+
+	n, err = sr.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	n, err = sr.Read(buf[:0])
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	_, err = sr.Read(buf)
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestStreamWithKeepSpill(t *testing.T) {
+	data := make([]byte, int(minCachedSize)+1024)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	var spill string
+	hash, err := Stream(io.MultiReader(bytes.NewReader(data)), WithCachedSize(minCachedSize), WithKeepSpill(&spill), WithCleanup())
+	require.NoError(t, err)
+	require.NotEmpty(t, spill)
+	defer os.Remove(spill)
+
+	kept, err := os.ReadFile(spill)
+	require.NoError(t, err)
+	require.Equal(t, data, kept)
+
+	expectedHash, err := Bytes(data)
+	require.NoError(t, err)
+	require.Equal(t, expectedHash, hash)
+
+	// Nothing is spilled for small streams
+	spill = "unset"
+	_, err = Stream(io.MultiReader(bytes.NewReader(data[:1024])), WithKeepSpill(&spill))
+	require.NoError(t, err)
+	require.Empty(t, spill)
+}
+
+func TestCompareStreams(t *testing.T) {
+	data1 := make([]byte, 10000)
+	for i := range data1 {
+		data1[i] = byte(i % 256)
+	}
+	data2 := bytes.Clone(data1)
+	data2[5000] ^= 0xFF
+
+	h1, err := Bytes(data1)
+	require.NoError(t, err)
+	h2, err := Bytes(data2)
+	require.NoError(t, err)
+	expected, expectedErr := Compare(h1, h2)
+
+	s, err := CompareStreams(bytes.NewReader(data1), bytes.NewReader(data2), int64(len(data1)), int64(len(data2)))
+	require.Equal(t, expectedErr, err)
+	require.Equal(t, expected, s)
+
+	// Unknown sizes fall back to caching
+	s, err = CompareStreams(io.MultiReader(bytes.NewReader(data1)), io.MultiReader(bytes.NewReader(data2)), -1, -1)
+	require.Equal(t, expectedErr, err)
+	require.Equal(t, expected, s)
+}
+
+func TestMatchReader(t *testing.T) {
+	data, err := os.ReadFile("testdata/sample1.txt")
+	require.NoError(t, err)
+	hash, err := Bytes(data)
+	require.NoError(t, err)
+
+	changed := bytes.Clone(data)
+	changed[len(changed)/2] ^= 0xFF
+	similar, err := Bytes(changed)
+	require.NoError(t, err)
+	similarScore, err := Compare(hash, similar)
+	require.NoError(t, err)
+	require.Less(t, similarScore, 100)
+
+	known := []string{"invalid", "3:AXA:B", similar, hash}
+
+	idx, score, err := MatchReader(bytes.NewReader(data), int64(len(data)), known, 100)
+	require.NoError(t, err)
+	require.Equal(t, 3, idx)
+	require.Equal(t, 100, score)
+
+	// The search stops at the first hash meeting the threshold
+	idx, score, err = MatchReader(bytes.NewReader(data), int64(len(data)), known, similarScore)
+	require.NoError(t, err)
+	require.Equal(t, 2, idx)
+	require.Equal(t, similarScore, score)
+
+	// Without one, the best match is returned; unknown sizes are cached
+	idx, score, err = MatchReader(io.MultiReader(bytes.NewReader(data)), -1, known[:3], 100)
+	require.NoError(t, err)
+	require.Equal(t, 2, idx)
+	require.Equal(t, similarScore, score)
+
+	idx, score, err = MatchReader(bytes.NewReader(data), int64(len(data)), known[:2], 1)
+	require.NoError(t, err)
+	require.Equal(t, -1, idx)
+	require.Zero(t, score)
+
+	_, _, err = MatchReader(iotest.ErrReader(io.ErrUnexpectedEOF), 100, known, 100)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestStreamPipe(t *testing.T) {
+	data := []byte("The quick brown fox jumps over the lazy dog")
+
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	defer r.Close()
+
+	go func() {
+		defer w.Close()
+		w.Write(data)
+	}()
+
+	hash, err := Stream(r)
+	require.NoError(t, err)
+
+	expectedHash, err := Bytes(data)
+	require.NoError(t, err)
+	require.Equal(t, expectedHash, hash)
+}
+
+// statOverride reports the Stat result of another file
+type statOverride struct {
+	io.Reader
+	info os.FileInfo
+}
+
+func (s statOverride) Stat() (os.FileInfo, error) {
+	return s.info, nil
+}
+
+func TestStreamSizeMismatch(t *testing.T) {
+	data, err := os.ReadFile("testdata/sample1.txt")
+	require.NoError(t, err)
+	info, err := os.Stat("testdata/sample2.txt")
+	require.NoError(t, err)
+
+	_, err = Stream(statOverride{Reader: bytes.NewReader(data), info: info})
+	require.ErrorIs(t, err, ErrSizeMismatch)
+}
+
+func TestStateBytesWritten(t *testing.T) {
+	state := newSSDeepState(minBlockSize)
+	defer state.Close()
+
+	require.Zero(t, state.bytesWritten())
+	state.Write(make([]byte, 1000))
+	state.Write(make([]byte, 24))
+	require.Equal(t, int64(1024), state.bytesWritten())
+}
+
+// zeroReader yields an endless stream of zero bytes
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestHashLargerThan4GB(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping 5 GB hash in short mode")
+	}
+
+	const size = 5 << 30
+	state := newSSDeepState(estimateBlockSize(size))
+	defer state.Close()
+
+	n, err := io.Copy(state, io.LimitReader(zeroReader{}, size))
+	require.NoError(t, err)
+	require.Equal(t, int64(size), n)
+	require.Equal(t, int64(size), state.bytesWritten())
+
+	hash := state.Sum()
+	require.Equal(t, hash, state.Sum())
+	_, err = Parse(hash)
+	require.NoError(t, err)
+}
+
+func TestWindowIndexPast4GB(t *testing.T) {
+	data := make([]byte, 4096)
+	for i := range data {
+		data[i] = byte(i * 7 % 251)
+	}
+
+	// A state whose byte counter crosses 2^32 must keep the same window
+	// position as one that started at the equivalent offset modulo windowSize
+	const start = 1<<32 - 3
+	a := newSSDeepState(minBlockSize)
+	defer a.Close()
+	a.n = start
+	b := newSSDeepState(minBlockSize)
+	defer b.Close()
+	b.n = start % windowSize
+
+	// The window index is derived from the counter at the start of each Write
+	for _, chunk := range [][]byte{data[:10], data[10:]} {
+		a.Write(chunk)
+		b.Write(chunk)
+	}
+	require.Equal(t, b.Sum(), a.Sum())
+}
+
+// noEndSeeker is seekable but cannot report its size by seeking to the end
+type noEndSeeker struct {
+	*bytes.Reader
+}
+
+func (s noEndSeeker) Seek(offset int64, whence int) (int64, error) {
+	if whence == io.SeekEnd {
+		return 0, errors.New("seek end unsupported")
+	}
+	return s.Reader.Seek(offset, whence)
+}
+
+func TestStreamTwoPassSeeker(t *testing.T) {
+	data := make([]byte, int(minCachedSize)*2)
+	for i := range data {
+		data[i] = byte(i % 253)
+	}
+
+	var spill string
+	hash, err := Stream(noEndSeeker{bytes.NewReader(data)}, WithCachedSize(minCachedSize), WithKeepSpill(&spill))
+	require.NoError(t, err)
+	require.Empty(t, spill, "Seekable reader should not spill to a temp file")
+
+	expectedHash, err := Bytes(data)
+	require.NoError(t, err)
+	require.Equal(t, expectedHash, hash)
+
+	// Only the remaining bytes are hashed
+	r := bytes.NewReader(data)
+	_, err = r.Seek(1024, io.SeekStart)
+	require.NoError(t, err)
+	hash, err = Stream(r)
+	require.NoError(t, err)
+	expectedHash, err = Bytes(data[1024:])
+	require.NoError(t, err)
+	require.Equal(t, expectedHash, hash)
+}
+
+func TestFileStatsOnce(t *testing.T) {
+	var calls int
+	t.Cleanup(func() { statFile = (*os.File).Stat })
+	statFile = func(f *os.File) (os.FileInfo, error) {
+		calls++
+		return f.Stat()
+	}
+
+	hash, err := File("testdata/sample1.txt")
+	require.NoError(t, err)
+	require.Equal(t, "3:FJKKIUKact:FHIGi", hash)
+	require.Equal(t, 1, calls)
+
+	_, err = FileContext(context.Background(), "testdata/sample1.txt")
+	require.NoError(t, err)
+	require.Equal(t, 2, calls)
+
+	// A known size needs no Stat at all
+	hash, err = File("testdata/sample1.txt", WithFixedSize(43))
+	require.NoError(t, err)
+	require.Equal(t, "3:FJKKIUKact:FHIGi", hash)
+	require.Equal(t, 2, calls)
+}
+
+func TestFileOptions(t *testing.T) {
+	const path = "testdata/sample2.txt"
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	for _, options := range [][]Option{
+		{WithBlockSize(48)},
+		{WithHashSeed(0xbeef)},
+		// A fixed size overrides the Stat size, selecting the block size for 100000 bytes
+		{WithFixedSize(100000)},
+		{WithFixedSize(100000), WithBlockSize(12)},
+	} {
+		want, err := Stream(io.MultiReader(bytes.NewReader(data)), options...)
+		require.NoError(t, err)
+		got, err := File(path, options...)
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	}
+
+	hash, err := File(path, WithFixedSize(100000))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(hash, "3072:"), hash)
+	hash, err = File(path, WithFixedSize(100000), WithBlockSize(12))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(hash, "12:"), hash)
+}
@@ -0,0 +1,36 @@
+package ssdeep
+
+import (
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// createTempFile creates an anonymous temporary file with O_TMPFILE, which has no
+// directory entry and is released by the kernel on close, even if the process crashes.
+// Filesystems or kernels without O_TMPFILE support fall back to a named temporary file.
+// The returned named flag reports whether the caller must remove the file after closing it.
+func createTempFile() (file *os.File, named bool, err error) {
+	dir := os.TempDir()
+	fd, err := unix.Open(dir, unix.O_TMPFILE|unix.O_RDWR|unix.O_CLOEXEC, 0o600)
+	if err == nil {
+		return os.NewFile(uintptr(fd), filepath.Join(dir, "ssdeep-tmpfile")), false, nil
+	}
+
+	file, err = os.CreateTemp("", "ssdeep-*")
+	return file, true, err
+}
+
+// preallocate reserves size bytes of disk space for file, so that spilling a stream
+// writes one contiguous extent instead of extending the file a chunk at a time.
+// FALLOC_FL_KEEP_SIZE leaves the file length alone, so an overestimated hint never
+// shows up as trailing zeros when the spill file is read back. Preallocation is only
+// an optimization, so errors are ignored: filesystems without fallocate support
+// (EOPNOTSUPP) just grow the file as it is written, as do other platforms.
+func preallocate(file *os.File, size int64) {
+	if size <= 0 {
+		return
+	}
+	unix.Fallocate(int(file.Fd()), unix.FALLOC_FL_KEEP_SIZE, 0, size)
+}
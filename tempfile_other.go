@@ -0,0 +1,14 @@
+//go:build !linux
+
+package ssdeep
+
+import "os"
+
+// createTempFile creates a named temporary file that the caller must remove after closing it
+func createTempFile() (file *os.File, named bool, err error) {
+	file, err = os.CreateTemp("", "ssdeep-*")
+	return file, true, err
+}
+
+// preallocate does nothing; reserving space relies on fallocate, used on Linux only
+func preallocate(file *os.File, size int64) {}
@@ -0,0 +1,64 @@
+package ssdeep
+
+import "bytes"
+
+// Transformation maps data to a canonical form before hashing, so that inputs differing
+// only in ways the form discards hash alike. ssdeep is sensitive to byte order and
+// to every inserted byte, which spreads a change such as reindented text over many
+// chunks; hashing a canonical form as well recovers those near-duplicates.
+type Transformation func(data []byte) []byte
+
+// NormalizeWhitespace is a Transformation for UTF-8 text that replaces every run of
+// whitespace, as defined by unicode.IsSpace, with a single space and trims it at both
+// ends, so that reindented, rewrapped or CRLF-converted copies of a text hash alike.
+func NormalizeWhitespace(data []byte) []byte {
+	return bytes.Join(bytes.Fields(data), []byte(" "))
+}
+
+// NormalizeLineEndings is a Transformation for text that converts CRLF and lone CR line
+// endings to LF, keeping all other bytes.
+func NormalizeLineEndings(data []byte) []byte {
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(data, []byte("\r"), []byte("\n"))
+}
+
+// HashTransformed returns the hash of data followed by the hashes of data transformed
+// by each of transforms, in order. No transformation is applied unless asked for.
+// The hashes of two inputs computed with the same transforms are compared with
+// CompareTransformed.
+func HashTransformed(data []byte, transforms ...Transformation) []string {
+	hashes := make([]string, 0, 1+len(transforms))
+	hashes = append(hashes, SumBytes(data))
+	for _, t := range transforms {
+		hashes = append(hashes, SumBytes(t(data)))
+	}
+	return hashes
+}
+
+// CompareTransformed compares the hash lists of two inputs, as returned by HashTransformed
+// with the same transformations, and returns the best score of the pairs computed the
+// same way. Only the lengths of the lists can be checked: lists of different lengths yield
+// ErrTransformationMismatch. Pairs that cannot be compared are skipped; if none can, the
+// error of the first pair is returned.
+func CompareTransformed(hashes1, hashes2 []string) (int, error) {
+	if len(hashes1) != len(hashes2) {
+		return 0, ErrTransformationMismatch
+	}
+
+	best, compared := 0, false
+	var firstErr error
+	for i := range hashes1 {
+		score, err := Compare(hashes1[i], hashes2[i])
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		best, compared = max(best, score), true
+	}
+	if !compared {
+		return 0, firstErr
+	}
+	return best, nil
+}
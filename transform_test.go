@@ -0,0 +1,49 @@
+package ssdeep
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalize(t *testing.T) {
+	require.Equal(t, "a b c", string(NormalizeWhitespace([]byte("  a\t\tb\r\n  c\n"))))
+	require.Empty(t, NormalizeWhitespace([]byte(" \n\t ")))
+	require.Equal(t, "a\nb\nc\n", string(NormalizeLineEndings([]byte("a\r\nb\rc\n"))))
+}
+
+func TestCompareTransformed(t *testing.T) {
+	var original, reformatted strings.Builder
+	for i := range 200 {
+		fmt.Fprintf(&original, "func step%d() { return compute(%d, value) }\n", i, i)
+		// The same code, reindented over several lines with CRLF line endings
+		fmt.Fprintf(&reformatted, "func step%d() {\r\n\treturn  compute(%d,  value)\r\n}\r\n", i, i)
+	}
+
+	transforms := []Transformation{NormalizeLineEndings, NormalizeWhitespace}
+	hashes1 := HashTransformed([]byte(original.String()), transforms...)
+	hashes2 := HashTransformed([]byte(reformatted.String()), transforms...)
+	require.Len(t, hashes1, 3)
+
+	raw, err := Compare(hashes1[0], hashes2[0])
+	require.NoError(t, err)
+	best, err := CompareTransformed(hashes1, hashes2)
+	require.NoError(t, err)
+	require.Equal(t, 100, best, "whitespace-normalized variants should be identical")
+	require.Less(t, raw, best)
+
+	// Without transformations CompareTransformed is Compare
+	score, err := CompareTransformed(hashes1[:1], hashes2[:1])
+	require.NoError(t, err)
+	require.Equal(t, raw, score)
+
+	_, err = CompareTransformed([]string{"garbage"}, []string{"garbage"})
+	require.ErrorIs(t, err, ErrInvalidHash)
+
+	_, err = CompareTransformed(hashes1, hashes2[:2])
+	require.ErrorIs(t, err, ErrTransformationMismatch)
+	_, err = CompareTransformed([]string{"invalid"}, []string{"3:FJKKIUKact:FHIGi"})
+	require.ErrorIs(t, err, ErrInvalidHash)
+}